@@ -2,13 +2,15 @@ module blockchain
 
 go 1.23.2
 
-require github.com/dgraph-io/badger v1.6.2
+require (
+	github.com/dgraph-io/badger v1.6.2
+	github.com/stretchr/testify v1.9.0
+	github.com/vrecan/death v3.0.1+incompatible
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
-	github.com/vrecan/death v3.0.1+incompatible // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"blockchain/pkg/blockchain"
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/utils"
+	"fmt"
+)
+
+// NOTE issues units of the asset `name` of the `from` wallet, the asset ID is printed
+// NOTE and then used with `send -asset` and shown by `getbalance`
+func (cli *CommandLine) issueAsset(from, name string, amount blockchain.Amount, to, nodeId string, mineNow bool) {
+	if !wallet.ValidateAddress(from) || !wallet.ValidateAddress(to) {
+		utils.DisplayErr("Address is not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	wallets, err := wallet.CreateWallets(nodeId)
+	utils.DisplayErr(err)
+	w := wallets.GetWallet(from)
+
+	tx := blockchain.NewIssueTransaction(w, name, amount, to, &UTXOSet)
+	submitTx(chain, &UTXOSet, tx, from, mineNow)
+
+	fmt.Printf("Issued %s %s, asset %x\n", amount, name, tx.Issue.AssetID())
+}
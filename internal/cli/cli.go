@@ -5,11 +5,14 @@ import (
 	"blockchain/pkg/blockchain/wallet"
 	"blockchain/pkg/network"
 	"blockchain/pkg/utils"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
+	"time"
 )
 
 type CommandLine struct{}
@@ -19,11 +22,35 @@ func (cli *CommandLine) printUsage() {
 	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
 	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
 	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine - Send amount of coins. Then -mine flag is set, mine off of this node")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT -asset HEX -data HEX -strategy bnb -mine - Send amount of coins, optionally anchoring HEX data. Then -mine flag is set, mine off of this node")
+	fmt.Println("      -to may repeat as -to ADDRESS:AMOUNT, or -csv FILE reads address,amount lines; all go in one transaction")
+	fmt.Println("      -strategy picks inputs: bnb (no change when possible), largest, smallest or oldest")
+	fmt.Println("      -asset HEX sends units of an asset instead of coins")
+	fmt.Println(" issue -from ISSUER -name NAME -amount AMOUNT -to ADDRESS -mine - Issue units of the asset NAME of ISSUER")
+	fmt.Println(" finddata -prefix HEX - Find data carrier outputs whose payload starts with HEX")
+	fmt.Println(" createpsbt -from FROM -to TO -amount AMOUNT -out FILE - Build an unsigned transaction, no private key needed")
+	fmt.Println(" signpsbt -in FILE -out FILE -address ADDRESS -sighash ALL - Sign the inputs of ADDRESS, works offline")
+	fmt.Println(" finalizepsbt -in FILE[,FILE] -miner ADDRESS - Combine, finalize and send. With -miner, mine off of this node")
+	fmt.Println(" swapinitiate -from FROM -to TO -amount AMOUNT -blocks 48 -mine - Lock coins for TO under a new secret, prints the secret")
+	fmt.Println(" swapparticipate -from FROM -to TO -amount AMOUNT -hash HEX -blocks 24 -mine - Lock coins for TO under the initiator's hash")
+	fmt.Println(" swapredeem -contract TXID:VOUT -address ADDRESS -secret HEX -mine - Take the coins of a contract with its secret")
+	fmt.Println(" swaprefund -contract TXID:VOUT -address ADDRESS -mine - Take back the coins of an expired contract")
+	fmt.Println(" swapsecret -contract TXID:VOUT - Print the secret revealed by the redeem of a contract")
 	fmt.Println(" createwallet - Creates a new Wallet")
 	fmt.Println(" listaddresses - Lists the addresses in our wallet file")
-	fmt.Println(" reindex - change the indexes of transactions")
-	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println(" reindex -addrindex -dbcache 64 - change the indexes of transactions. -addrindex also builds the address index, -dbcache sets the UTXO cache in MB")
+	fmt.Println(" listunspent -address ADDRESS - List unspent outputs of the address, needs the address index")
+	fmt.Println(" listtransactions -address ADDRESS - List confirmed transactions of the address, needs the address index")
+	fmt.Println(" history -address ADDRESS -skip 0 -count 20 - Transactions of the address newest first, with amounts, counterparties and confirmations")
+	fmt.Println(" chainstats -top 10 - Issued supply, UTXO count and value, funded addresses, the richest ones and balance buckets")
+	fmt.Println(" gettxproof -txid HEX - Print the proof that the transaction is in its block, checkable against the block header")
+	fmt.Println(" verifytxproof -proof HEX - Check a proof made by gettxproof against the headers of this node")
+	fmt.Println(" gettxoutsetinfo - Print the UTXO set hash, tip height and totals, to compare nodes")
+	fmt.Println(" dumptxoutset -out FILE - Write the UTXO set and its hash to FILE")
+	fmt.Println(" loadtxoutset -in FILE -hash HASH - Start a fresh node from a UTXO snapshot with the set hash HASH, startnode then fetches and checks the history")
+	fmt.Println(" startnode -miner ADDRESS -dbcache 64 -prune N -spv - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("      -prune N keeps the bodies of the last N blocks only, old blocks can't be served afterwards")
+	fmt.Println("      -spv starts a light node keeping headers and proven transactions of its wallet, getbalance then reads those")
 }
 
 func (cli *CommandLine) validateArgs() {
@@ -92,25 +119,171 @@ func (cli *CommandLine) getBalance(address, nodeId string) {
 		utils.DisplayErr("Address is not valid")
 	}
 
+	pubKeyHash := wallet.AddressPubKeyHash(address)
+
+	var balances map[string]blockchain.Amount
+
+	// NOTE a light node knows the transactions full nodes proved to it
+	if blockchain.IsSPVNode(nodeId) {
+		chain, err := blockchain.OpenHeaderChain(nodeId)
+		utils.DisplayErr(err)
+		defer chain.Database.Close()
+
+		balances, err = chain.ProvenBalances(pubKeyHash)
+		utils.DisplayErr(err)
+	} else {
+		chain := blockchain.ContinueBlockchain(nodeId)
+		UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+		defer chain.Database.Close()
+
+		var err error
+		balances, err = UTXOSet.Balances(pubKeyHash)
+		utils.DisplayErr(err)
+	}
+
+	fmt.Printf("Balance of %s: %s\n", address, balances[""])
+
+	// NOTE sorted, so the output is the same on every run
+	var assets []string
+	for asset := range balances {
+		if asset != "" {
+			assets = append(assets, asset)
+		}
+	}
+	sort.Strings(assets)
+
+	for _, asset := range assets {
+		fmt.Printf("  asset %s: %s\n", asset, balances[asset])
+	}
+}
+
+// NOTE needs the address index, see `reindex -addrindex`
+func (cli *CommandLine) listUnspent(address, nodeId string) {
+	if !wallet.ValidateAddress(address) {
+		utils.DisplayErr("Address is not valid")
+	}
+
 	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
 	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	if !UTXOSet.AddressIndexEnabled() {
+		utils.DisplayErr("Address index is off, run `reindex -addrindex` first")
+	}
+
+	outs := UTXOSet.AddressUnspent(wallet.AddressPubKeyHash(address))
+	for _, out := range outs {
+		if out.Output.IsAsset() {
+			fmt.Printf("%x:%d  %s  asset %x  height %d\n", out.TxID, out.Out, out.Output.Value, out.Output.Asset, out.Height)
+		} else {
+			fmt.Printf("%x:%d  %s  height %d\n", out.TxID, out.Out, out.Output.Value, out.Height)
+		}
+	}
+
+	fmt.Printf("%d unspent outputs\n", len(outs))
+}
+
+func (cli *CommandLine) listTransactions(address, nodeId string) {
+	if !wallet.ValidateAddress(address) {
+		utils.DisplayErr("Address is not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeId)
 	defer chain.Database.Close()
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
 
-	balance := 0
-	pubKeyHash := utils.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUnspentTransactions(pubKeyHash)
+	if !UTXOSet.AddressIndexEnabled() {
+		utils.DisplayErr("Address index is off, run `reindex -addrindex` first")
+	}
 
-	for _, out := range UTXOs {
-		balance += out.Value
+	history := UTXOSet.AddressTransactions(wallet.AddressPubKeyHash(address))
+	for _, tx := range history {
+		fmt.Printf("%x  height %d\n", tx.TxID, tx.Height)
 	}
 
-	fmt.Printf("Balance of %s: %d\n", address, balance)
+	fmt.Printf("%d transactions\n", len(history))
 }
 
-func (cli *CommandLine) StartNode(nodeID, minerAddress string) {
+func (cli *CommandLine) history(address string, skip, count int, nodeId string) {
+	if !wallet.ValidateAddress(address) {
+		utils.DisplayErr("Address is not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	history, total, err := UTXOSet.History(wallet.AddressPubKeyHash(address), skip, count)
+	utils.DisplayErr(err)
+
+	for _, entry := range history {
+		kind := ""
+		if entry.Coinbase {
+			kind = " coinbase"
+		}
+
+		fmt.Printf("%x  height %d  %s  %s  confirmations %d%s\n", entry.TxID, entry.Height,
+			time.Unix(entry.Timestamp, 0).UTC().Format(time.RFC3339), entry.Net(), entry.Confirmations, kind)
+		for _, other := range entry.Counterparties {
+			fmt.Printf("    %s\n", other)
+		}
+	}
+
+	fmt.Printf("%d-%d of %d transactions\n", min(skip+1, total), skip+len(history), total)
+}
+
+func (cli *CommandLine) chainStats(top int, nodeId string) {
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	stats, err := UTXOSet.ChainStats(top)
+	utils.DisplayErr(err)
+
+	fmt.Printf("Height:     %d\n", stats.Height)
+	fmt.Printf("Best block: %x\n", stats.BestBlock)
+	fmt.Printf("Issued:     %s\n", stats.Issued)
+	fmt.Printf("Outputs:    %d\n", stats.Outputs)
+	fmt.Printf("Total:      %s\n", stats.Total)
+	fmt.Printf("Addresses:  %d\n", stats.Addresses)
+
+	fmt.Println("Top balances:")
+	for i, holder := range stats.Top {
+		fmt.Printf(" %3d. %s  %s\n", i+1, holder.Address, holder.Balance)
+	}
+
+	fmt.Println("Balances:")
+	for _, bucket := range stats.Buckets {
+		upper := "..."
+		if bucket.Max > 0 {
+			upper = bucket.Max.String()
+		}
+		fmt.Printf(" [%s, %s)  %d addresses  %s\n", bucket.Min, upper, bucket.Addresses, bucket.Total)
+	}
+}
+
+// NOTE same hash on two nodes at the same height means the same set
+func (cli *CommandLine) getTxOutSetInfo(nodeId string) {
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	summary := UTXOSet.TxOutSetInfo()
+
+	fmt.Printf("Height:     %d\n", summary.Height)
+	fmt.Printf("Best block: %x\n", summary.BestBlock)
+	fmt.Printf("Outputs:    %d\n", summary.Outputs)
+	fmt.Printf("Total:      %s\n", summary.Total)
+	fmt.Printf("Set hash:   %x\n", summary.Hash)
+}
+
+func (cli *CommandLine) StartNode(nodeID, minerAddress string, prune int, spv bool) {
 	fmt.Printf("Starting Node %s\n", nodeID)
 
+	if spv && (len(minerAddress) > 0 || prune > 0) {
+		utils.DisplayErr("A light node neither mines nor prunes")
+	}
+
 	if len(minerAddress) > 0 {
 		if wallet.ValidateAddress(minerAddress) {
 			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
@@ -118,32 +291,32 @@ func (cli *CommandLine) StartNode(nodeID, minerAddress string) {
 			utils.DisplayErr("Wrong miner address!")
 		}
 	}
-	network.StartServer(nodeID, minerAddress)
+	network.StartServer(nodeID, minerAddress, prune, spv)
 }
 
-func (cli *CommandLine) send(from, to string, amount int, nodeId string, mineNow bool) {
+func (cli *CommandLine) send(from string, recipients []blockchain.Recipient, data []byte, strategy, nodeId string, mineNow bool) {
 	if !wallet.ValidateAddress(from) {
 		utils.DisplayErr("Address is not valid")
 	}
-	if !wallet.ValidateAddress(to) {
-		utils.DisplayErr("Address is not valid")
-	}
+
+	selector, err := blockchain.SelectorByName(strategy)
+	utils.DisplayErr(err)
 
 	chain := blockchain.ContinueBlockchain(nodeId)
 	// pass the reference to the blockchain
-	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain, Selector: selector}
 	defer chain.Database.Close()
 
 	// create a transaction from followed arguments
 	wallets, err := wallet.CreateWallets(nodeId)
 	utils.DisplayErr(err)
 	wallet := wallets.GetWallet(from)
-	tx := blockchain.NewTransaction(wallet, to, amount, &UTXOSet)
+	tx := blockchain.NewBatchTransaction(wallet, recipients, data, &UTXOSet)
 	if mineNow {
 		cbTx := blockchain.CoinbaseTx(from, "")
 		txs := []*blockchain.Transaction{cbTx, tx}
 		block := chain.MineBlock(txs)
-		UTXOSet.Update(block)
+		utils.DisplayErr(UTXOSet.Update(block))
 	} else {
 		network.SendTx(network.KnownNodes[0], tx)
 		fmt.Println("send tx")
@@ -151,14 +324,42 @@ func (cli *CommandLine) send(from, to string, amount int, nodeId string, mineNow
 	fmt.Println("Success!")
 }
 
-func (cli *CommandLine) reindexUTXO(nodeId string) {
+func (cli *CommandLine) findData(prefix []byte, nodeId string) {
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+
+	records, err := chain.FindDataCarriers(prefix)
+	utils.DisplayErr(err)
+
+	for _, record := range records {
+		fmt.Printf("Data:   %x\n", record.Data)
+		fmt.Printf("Tx:     %x:%d\n", record.TxID, record.Out)
+		fmt.Printf("Block:  %x (height %d)\n", record.BlockHash, record.Height)
+		fmt.Println()
+	}
+
+	fmt.Printf("Found %d data outputs\n", len(records))
+}
+
+func (cli *CommandLine) reindexUTXO(nodeId string, addrIndex bool) {
 	chain := blockchain.ContinueBlockchain(nodeId)
 	defer chain.Database.Close()
 	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	// NOTE an interrupted reindex goes on from its last checkpoint
+	UTXOSet.Progress = func(p blockchain.ReindexProgress) {
+		fmt.Printf("\rBlock %d/%d  %.1f blocks/s  ETA %s   ", p.Height, p.Tip, p.BlocksPerSec, p.ETA.Round(time.Second))
+	}
 	UTXOSet.Reindex()
+	fmt.Println()
+
+	if addrIndex && !UTXOSet.AddressIndexEnabled() {
+		UTXOSet.EnableAddressIndex()
+		fmt.Println("Address index is on")
+	}
 
 	count := UTXOSet.CountUnspentOuts()
-	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+	fmt.Printf("Done! There are %d unspent outputs in the UTXO set.\n", count)
 }
 
 func (cli *CommandLine) Run() {
@@ -178,15 +379,90 @@ func (cli *CommandLine) Run() {
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	findDataCmd := flag.NewFlagSet("finddata", flag.ExitOnError)
+	issueCmd := flag.NewFlagSet("issue", flag.ExitOnError)
+	listUnspentCmd := flag.NewFlagSet("listunspent", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	chainStatsCmd := flag.NewFlagSet("chainstats", flag.ExitOnError)
+	getTxProofCmd := flag.NewFlagSet("gettxproof", flag.ExitOnError)
+	verifyTxProofCmd := flag.NewFlagSet("verifytxproof", flag.ExitOnError)
+	getTxOutSetInfoCmd := flag.NewFlagSet("gettxoutsetinfo", flag.ExitOnError)
+	dumpTxOutSetCmd := flag.NewFlagSet("dumptxoutset", flag.ExitOnError)
+	loadTxOutSetCmd := flag.NewFlagSet("loadtxoutset", flag.ExitOnError)
+	createPSBTCmd := flag.NewFlagSet("createpsbt", flag.ExitOnError)
+	signPSBTCmd := flag.NewFlagSet("signpsbt", flag.ExitOnError)
+	finalizePSBTCmd := flag.NewFlagSet("finalizepsbt", flag.ExitOnError)
+	swapInitiateCmd := flag.NewFlagSet("swapinitiate", flag.ExitOnError)
+	swapParticipateCmd := flag.NewFlagSet("swapparticipate", flag.ExitOnError)
+	swapRedeemCmd := flag.NewFlagSet("swapredeem", flag.ExitOnError)
+	swapRefundCmd := flag.NewFlagSet("swaprefund", flag.ExitOnError)
+	swapSecretCmd := flag.NewFlagSet("swapsecret", flag.ExitOnError)
 
 	// further options
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	reindexAddrIndex := reindexCmd.Bool("addrindex", false, "Build and keep the address index")
+	reindexDBCache := reindexCmd.Int("dbcache", 64, "UTXO cache size in MB, flushed when full")
+	listUnspentAddress := listUnspentCmd.String("address", "", "The address to list outputs of")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "The address to list transactions of")
+	historyAddress := historyCmd.String("address", "", "The address to list the history of")
+	historySkip := historyCmd.Int("skip", 0, "Newest transactions to skip")
+	historyCount := historyCmd.Int("count", 20, "Transactions per page, 0 lists all")
+	chainStatsTop := chainStatsCmd.Int("top", 10, "Richest addresses to list, at most 100")
+	getTxProofTxID := getTxProofCmd.String("txid", "", "Hex encoded transaction ID")
+	verifyTxProofProof := verifyTxProofCmd.String("proof", "", "Hex encoded proof printed by gettxproof")
+	dumpTxOutSetOut := dumpTxOutSetCmd.String("out", "", "File to write the snapshot to")
+	loadTxOutSetIn := loadTxOutSetCmd.String("in", "", "Snapshot file written by dumptxoutset")
+	loadTxOutSetHash := loadTxOutSetCmd.String("hash", "", "Set hash printed by dumptxoutset on a trusted node")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
-	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	var sendTo recipientList
+	sendCmd.Var(&sendTo, "to", "Destination ADDRESS or ADDRESS:AMOUNT, may be repeated")
+	sendCSV := sendCmd.String("csv", "", "File with address,amount lines")
+	sendAmount := sendCmd.String("amount", "", "Amount to send, e.g. 12.345")
+	sendData := sendCmd.String("data", "", "Hex encoded payload to anchor in a data carrier output")
+	sendStrategy := sendCmd.String("strategy", "bnb", "Coin selection: bnb, largest, smallest or oldest")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendAsset := sendCmd.String("asset", "", "Hex encoded asset ID, coins when empty")
+	issueFrom := issueCmd.String("from", "", "Issuer wallet address")
+	issueName := issueCmd.String("name", "", "Asset name, e.g. hours")
+	issueAmount := issueCmd.String("amount", "", "Units to issue, e.g. 12.5")
+	issueTo := issueCmd.String("to", "", "Address receiving the issued units")
+	issueMine := issueCmd.Bool("mine", false, "Mine immediately on the same node")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeDBCache := startNodeCmd.Int("dbcache", 64, "UTXO cache size in MB, flushed when full")
+	startNodePrune := startNodeCmd.Int("prune", 0, "Keep only the last N blocks, 0 keeps everything")
+	startNodeSPV := startNodeCmd.Bool("spv", false, "Light node: headers and proofs of the wallet transactions only")
+	findDataPrefix := findDataCmd.String("prefix", "", "Hex encoded payload prefix")
+	createPSBTFrom := createPSBTCmd.String("from", "", "Source wallet address")
+	createPSBTTo := createPSBTCmd.String("to", "", "Destination wallet address")
+	createPSBTAmount := createPSBTCmd.String("amount", "", "Amount to send, e.g. 12.345")
+	createPSBTOut := createPSBTCmd.String("out", "", "File to write the unsigned transaction to")
+	signPSBTIn := signPSBTCmd.String("in", "", "Partially signed transaction file")
+	signPSBTOut := signPSBTCmd.String("out", "", "File to write the signed transaction to")
+	signPSBTAddress := signPSBTCmd.String("address", "", "Wallet address to sign with")
+	signPSBTSigHash := signPSBTCmd.String("sighash", "ALL", "ALL, NONE or SINGLE, optionally with |ANYONECANPAY")
+	finalizePSBTIn := finalizePSBTCmd.String("in", "", "Comma separated partially signed transaction files")
+	finalizePSBTMiner := finalizePSBTCmd.String("miner", "", "Mine the transaction here and send reward to ADDRESS")
+	swapInitiateFrom := swapInitiateCmd.String("from", "", "Source wallet address, also the refund address")
+	swapInitiateTo := swapInitiateCmd.String("to", "", "Participant address on this chain")
+	swapInitiateAmount := swapInitiateCmd.String("amount", "", "Amount to lock, e.g. 12.345")
+	swapInitiateBlocks := swapInitiateCmd.Int("blocks", 48, "Blocks until the contract can be refunded")
+	swapInitiateMine := swapInitiateCmd.Bool("mine", false, "Mine immediately on the same node")
+	swapParticipateFrom := swapParticipateCmd.String("from", "", "Source wallet address, also the refund address")
+	swapParticipateTo := swapParticipateCmd.String("to", "", "Initiator address on this chain")
+	swapParticipateAmount := swapParticipateCmd.String("amount", "", "Amount to lock, e.g. 12.345")
+	swapParticipateHash := swapParticipateCmd.String("hash", "", "Hex encoded hash from the initiator's contract")
+	swapParticipateBlocks := swapParticipateCmd.Int("blocks", 24, "Blocks until the contract can be refunded, less than the initiator's")
+	swapParticipateMine := swapParticipateCmd.Bool("mine", false, "Mine immediately on the same node")
+	swapRedeemContract := swapRedeemCmd.String("contract", "", "Contract output as TXID:VOUT")
+	swapRedeemAddress := swapRedeemCmd.String("address", "", "Recipient wallet address of the contract")
+	swapRedeemSecret := swapRedeemCmd.String("secret", "", "Hex encoded secret")
+	swapRedeemMine := swapRedeemCmd.Bool("mine", false, "Mine immediately on the same node")
+	swapRefundContract := swapRefundCmd.String("contract", "", "Contract output as TXID:VOUT")
+	swapRefundAddress := swapRefundCmd.String("address", "", "Refund wallet address of the contract")
+	swapRefundMine := swapRefundCmd.Bool("mine", false, "Mine immediately on the same node")
+	swapSecretContract := swapSecretCmd.String("contract", "", "Contract output as TXID:VOUT")
 
 	switch os.Args[1] {
 	case "startnode":
@@ -213,6 +489,63 @@ func (cli *CommandLine) Run() {
 	case "send":
 		err := sendCmd.Parse(os.Args[2:])
 		utils.DisplayErr(err)
+	case "listunspent":
+		err := listUnspentCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "chainstats":
+		err := chainStatsCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "gettxproof":
+		err := getTxProofCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "verifytxproof":
+		err := verifyTxProofCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "gettxoutsetinfo":
+		err := getTxOutSetInfoCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "dumptxoutset":
+		err := dumpTxOutSetCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "loadtxoutset":
+		err := loadTxOutSetCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "issue":
+		err := issueCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "finddata":
+		err := findDataCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "createpsbt":
+		err := createPSBTCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "signpsbt":
+		err := signPSBTCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "finalizepsbt":
+		err := finalizePSBTCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "swapinitiate":
+		err := swapInitiateCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "swapparticipate":
+		err := swapParticipateCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "swapredeem":
+		err := swapRedeemCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "swaprefund":
+		err := swapRefundCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
+	case "swapsecret":
+		err := swapSecretCmd.Parse(os.Args[2:])
+		utils.DisplayErr(err)
 	default:
 		cli.printUsage()
 		runtime.Goexit()
@@ -245,16 +578,191 @@ func (cli *CommandLine) Run() {
 		cli.listAddresses(nodeID)
 	}
 	if reindexCmd.Parsed() {
-		cli.reindexUTXO(nodeID)
+		blockchain.CoinsCacheBudget = *reindexDBCache << 20
+		cli.reindexUTXO(nodeID, *reindexAddrIndex)
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		recipients, err := sendTo.recipients(*sendAmount)
+		utils.DisplayErr(err)
+
+		if *sendCSV != "" {
+			fromCSV, err := readRecipientsCSV(*sendCSV)
+			utils.DisplayErr(err)
+			recipients = append(recipients, fromCSV...)
+		}
+
+		if *sendFrom == "" || len(recipients) == 0 {
 			sendCmd.Usage()
 			runtime.Goexit()
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		asset, err := hex.DecodeString(*sendAsset)
+		utils.DisplayErr(err)
+		for i := range recipients {
+			recipients[i].Asset = asset
+		}
+
+		total, err := sumRecipients(recipients)
+		utils.DisplayErr(err)
+		fmt.Printf("Sending %s to %d recipients\n", total, len(recipients))
+
+		data, err := hex.DecodeString(*sendData)
+		utils.DisplayErr(err)
+
+		cli.send(*sendFrom, recipients, data, *sendStrategy, nodeID, *sendMine)
+	}
+
+	if listUnspentCmd.Parsed() {
+		if *listUnspentAddress == "" {
+			listUnspentCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.listUnspent(*listUnspentAddress, nodeID)
+	}
+
+	if listTransactionsCmd.Parsed() {
+		if *listTransactionsAddress == "" {
+			listTransactionsCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.listTransactions(*listTransactionsAddress, nodeID)
+	}
+
+	if historyCmd.Parsed() {
+		if *historyAddress == "" || *historySkip < 0 {
+			historyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.history(*historyAddress, *historySkip, *historyCount, nodeID)
+	}
+
+	if chainStatsCmd.Parsed() {
+		cli.chainStats(*chainStatsTop, nodeID)
+	}
+
+	if getTxProofCmd.Parsed() {
+		if *getTxProofTxID == "" {
+			getTxProofCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getTxProof(*getTxProofTxID, nodeID)
+	}
+
+	if verifyTxProofCmd.Parsed() {
+		if *verifyTxProofProof == "" {
+			verifyTxProofCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.verifyTxProof(*verifyTxProofProof, nodeID)
+	}
+
+	if getTxOutSetInfoCmd.Parsed() {
+		cli.getTxOutSetInfo(nodeID)
+	}
+
+	if dumpTxOutSetCmd.Parsed() {
+		if *dumpTxOutSetOut == "" {
+			dumpTxOutSetCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.dumpTxOutSet(*dumpTxOutSetOut, nodeID)
+	}
+
+	if loadTxOutSetCmd.Parsed() {
+		if *loadTxOutSetIn == "" || *loadTxOutSetHash == "" {
+			loadTxOutSetCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.loadTxOutSet(*loadTxOutSetIn, *loadTxOutSetHash, nodeID)
+	}
+
+	if issueCmd.Parsed() {
+		amount, err := blockchain.ParseAmount(*issueAmount)
+		if *issueFrom == "" || *issueName == "" || *issueTo == "" || err != nil || amount <= 0 {
+			issueCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.issueAsset(*issueFrom, *issueName, amount, *issueTo, nodeID, *issueMine)
+	}
+
+	if findDataCmd.Parsed() {
+		prefix, err := hex.DecodeString(*findDataPrefix)
+		if err != nil || len(prefix) == 0 {
+			findDataCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.findData(prefix, nodeID)
+	}
+
+	if createPSBTCmd.Parsed() {
+		amount, err := blockchain.ParseAmount(*createPSBTAmount)
+		if *createPSBTFrom == "" || *createPSBTTo == "" || err != nil || amount <= 0 || *createPSBTOut == "" {
+			createPSBTCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createPSBT(*createPSBTFrom, *createPSBTTo, amount, *createPSBTOut, nodeID)
+	}
+
+	if signPSBTCmd.Parsed() {
+		if *signPSBTIn == "" || *signPSBTOut == "" || *signPSBTAddress == "" {
+			signPSBTCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.signPSBT(*signPSBTIn, *signPSBTOut, *signPSBTAddress, *signPSBTSigHash, nodeID)
+	}
+
+	if finalizePSBTCmd.Parsed() {
+		if *finalizePSBTIn == "" {
+			finalizePSBTCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.finalizePSBT(*finalizePSBTIn, *finalizePSBTMiner, nodeID)
+	}
+
+	if swapInitiateCmd.Parsed() {
+		amount, err := blockchain.ParseAmount(*swapInitiateAmount)
+		if *swapInitiateFrom == "" || *swapInitiateTo == "" || err != nil || amount <= 0 || *swapInitiateBlocks <= 0 {
+			swapInitiateCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.swapInitiate(*swapInitiateFrom, *swapInitiateTo, amount, *swapInitiateBlocks, nodeID, *swapInitiateMine)
+	}
+
+	if swapParticipateCmd.Parsed() {
+		amount, amountErr := blockchain.ParseAmount(*swapParticipateAmount)
+		hash, hashErr := hex.DecodeString(*swapParticipateHash)
+		if *swapParticipateFrom == "" || *swapParticipateTo == "" || amountErr != nil || amount <= 0 ||
+			hashErr != nil || len(hash) != 32 || *swapParticipateBlocks <= 0 {
+			swapParticipateCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.swapParticipate(*swapParticipateFrom, *swapParticipateTo, amount, hash, *swapParticipateBlocks, nodeID, *swapParticipateMine)
+	}
+
+	if swapRedeemCmd.Parsed() {
+		secret, err := hex.DecodeString(*swapRedeemSecret)
+		if *swapRedeemContract == "" || *swapRedeemAddress == "" || err != nil || len(secret) == 0 {
+			swapRedeemCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.swapRedeem(*swapRedeemContract, *swapRedeemAddress, secret, nodeID, *swapRedeemMine)
+	}
+
+	if swapRefundCmd.Parsed() {
+		if *swapRefundContract == "" || *swapRefundAddress == "" {
+			swapRefundCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.swapRefund(*swapRefundContract, *swapRefundAddress, nodeID, *swapRefundMine)
+	}
+
+	if swapSecretCmd.Parsed() {
+		if *swapSecretContract == "" {
+			swapSecretCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.swapSecret(*swapSecretContract, nodeID)
 	}
 
 	if startNodeCmd.Parsed() {
@@ -263,6 +771,7 @@ func (cli *CommandLine) Run() {
 			startNodeCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.StartNode(nodeID, *startNodeMiner)
+		blockchain.CoinsCacheBudget = *startNodeDBCache << 20
+		cli.StartNode(nodeID, *startNodeMiner, *startNodePrune, *startNodeSPV)
 	}
 }
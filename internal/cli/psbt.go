@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"blockchain/pkg/blockchain"
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/network"
+	"blockchain/pkg/utils"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// NOTE runs on the online node: needs the chain, but only the address of the payer
+func (cli *CommandLine) createPSBT(from, to string, amount blockchain.Amount, out, nodeId string) {
+	if !wallet.ValidateAddress(from) || !wallet.ValidateAddress(to) {
+		utils.DisplayErr("Address is not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	psbt, err := blockchain.CreatePSBT(from, to, amount, &UTXOSet)
+	utils.DisplayErr(err)
+
+	err = os.WriteFile(out, []byte(psbt.Encode()), 0644)
+	utils.DisplayErr(err)
+
+	// NOTE signatures and public keys are not part of the ID, it stays the same once signed
+	fmt.Printf("Unsigned transaction %x with %d inputs written to %s\n", psbt.Tx.ID, len(psbt.Inputs), out)
+}
+
+// NOTE runs on the offline machine: needs the wallet file only, no chain
+func (cli *CommandLine) signPSBT(in, out, address, sigHash, nodeId string) {
+	hashType, err := blockchain.ParseSigHashType(sigHash)
+	utils.DisplayErr(err)
+
+	psbt := readPSBT(in)
+
+	wallets, err := wallet.CreateWallets(nodeId)
+	utils.DisplayErr(err)
+
+	w := wallets.GetWallet(address)
+	if w == nil {
+		utils.DisplayErr("Wallet is not found")
+	}
+
+	// NOTE the file comes from the online machine, what is signed is shown first
+	printPSBT(psbt)
+
+	signed, err := psbt.Sign(w.PrivateKey, hashType)
+	utils.DisplayErr(err)
+
+	err = os.WriteFile(out, []byte(psbt.Encode()), 0644)
+	utils.DisplayErr(err)
+
+	fmt.Printf("Signed %d inputs with %s, written to %s\n", signed, hashType, out)
+}
+
+// NOTE combines every file in `in` (comma separated), finalizes and mines or broadcasts
+func (cli *CommandLine) finalizePSBT(in, minerAddress, nodeId string) {
+	var psbts []*blockchain.PartiallySignedTransaction
+
+	for _, file := range strings.Split(in, ",") {
+		psbts = append(psbts, readPSBT(strings.TrimSpace(file)))
+	}
+
+	combined, err := blockchain.CombinePSBT(psbts...)
+	utils.DisplayErr(err)
+
+	tx, err := combined.Finalize()
+	utils.DisplayErr(err)
+
+	if len(minerAddress) > 0 {
+		if !wallet.ValidateAddress(minerAddress) {
+			utils.DisplayErr("Address is not valid")
+		}
+
+		chain := blockchain.ContinueBlockchain(nodeId)
+		defer chain.Database.Close()
+		UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+		cbTx := blockchain.CoinbaseTx(minerAddress, "")
+		block := chain.MineBlock([]*blockchain.Transaction{cbTx, tx})
+		utils.DisplayErr(UTXOSet.Update(block))
+	} else {
+		network.SendTx(network.KnownNodes[0], tx)
+		fmt.Println("send tx")
+	}
+
+	fmt.Printf("Finalized transaction %x\n", tx.ID)
+}
+
+func printPSBT(psbt *blockchain.PartiallySignedTransaction) {
+	fee, err := psbt.Fee()
+	utils.DisplayErr(err)
+
+	fmt.Printf("Transaction %x spends %d outputs\n", psbt.Tx.ID, len(psbt.Inputs))
+
+	for outIdx, out := range psbt.Tx.Output {
+		switch {
+		case out.IsDataCarrier():
+			fmt.Printf("  Output %d: data %x\n", outIdx, out.Data)
+		case out.HTLC != nil:
+			fmt.Printf("  Output %d: %s in a hash time lock\n", outIdx, out.Value)
+		case out.Channel != nil:
+			fmt.Printf("  Output %d: %s in a payment channel\n", outIdx, out.Value)
+		case out.IsAsset():
+			fmt.Printf("  Output %d: %s of asset %x to %s\n", outIdx, out.Value, out.Asset, wallet.PubKeyHashAddress(out.PubkeyHash))
+		default:
+			fmt.Printf("  Output %d: %s to %s\n", outIdx, out.Value, wallet.PubKeyHashAddress(out.PubkeyHash))
+		}
+	}
+
+	fmt.Printf("  Fee: %s\n", fee)
+}
+
+func readPSBT(file string) *blockchain.PartiallySignedTransaction {
+	data, err := os.ReadFile(file)
+	utils.DisplayErr(err)
+
+	psbt, err := blockchain.DecodePSBT(string(data))
+	utils.DisplayErr(err)
+
+	return psbt
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"blockchain/pkg/blockchain"
+	"blockchain/pkg/blockchain/wallet"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// NOTE value of the repeated `-to` flag, each entry is ADDRESS or ADDRESS:AMOUNT
+type recipientList []string
+
+func (r *recipientList) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *recipientList) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+// NOTE entries without an amount take the one from `-amount`
+func (r recipientList) recipients(defaultAmount string) ([]blockchain.Recipient, error) {
+	var recipients []blockchain.Recipient
+
+	for _, entry := range r {
+		address, amount, found := strings.Cut(entry, ":")
+		if !found {
+			amount = defaultAmount
+		}
+
+		value, err := blockchain.ParseAmount(amount)
+		if err != nil {
+			return nil, fmt.Errorf("bad amount in %q: %w", entry, err)
+		}
+
+		recipient, err := newRecipient(address, value)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
+
+// NOTE csv with `address,amount` lines, e.g. a payroll export
+func readRecipientsCSV(file string) ([]blockchain.Recipient, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []blockchain.Recipient
+
+	for line, record := range records {
+		value, err := blockchain.ParseAmount(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad amount: %w", file, line+1, err)
+		}
+
+		recipient, err := newRecipient(strings.TrimSpace(record[0]), value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line+1, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
+
+func newRecipient(address string, amount blockchain.Amount) (blockchain.Recipient, error) {
+	if !wallet.ValidateAddress(address) {
+		return blockchain.Recipient{}, fmt.Errorf("address %q is not valid", address)
+	}
+	if amount <= 0 {
+		return blockchain.Recipient{}, fmt.Errorf("amount for %s must be positive", address)
+	}
+
+	return blockchain.Recipient{Address: address, Amount: amount}, nil
+}
+
+// NOTE overflow checked total of the whole batch
+func sumRecipients(recipients []blockchain.Recipient) (blockchain.Amount, error) {
+	var amounts []blockchain.Amount
+
+	for _, r := range recipients {
+		amounts = append(amounts, r.Amount)
+	}
+
+	return blockchain.SumAmounts(amounts...)
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"blockchain/pkg/blockchain"
+	"blockchain/pkg/blockchain/wallet"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecipientList(t *testing.T) {
+	wallets := wallet.Wallets{Wallets: make(map[string]*wallet.Wallet)}
+	alice, bob := wallets.AddWallet(), wallets.AddWallet()
+
+	var list recipientList
+	assert.NoError(t, list.Set(alice+":1.5"))
+	assert.NoError(t, list.Set(bob))
+
+	recipients, err := list.recipients("2")
+	assert.NoError(t, err)
+	assert.Equal(t, []blockchain.Recipient{
+		{Address: alice, Amount: blockchain.Coin + blockchain.Coin/2},
+		{Address: bob, Amount: 2 * blockchain.Coin},
+	}, recipients)
+
+	total, err := sumRecipients(recipients)
+	assert.NoError(t, err)
+	assert.Equal(t, 3*blockchain.Coin+blockchain.Coin/2, total)
+
+	// NOTE without `-amount` an entry needs its own
+	_, err = list.recipients("")
+	assert.Error(t, err)
+
+	for _, bad := range []string{alice + ":abc", alice + ":0", alice + ":-1", "nobody:1"} {
+		_, err = recipientList{bad}.recipients("1")
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestReadRecipientsCSV(t *testing.T) {
+	wallets := wallet.Wallets{Wallets: make(map[string]*wallet.Wallet)}
+	alice, bob := wallets.AddWallet(), wallets.AddWallet()
+	dir := t.TempDir()
+
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0644))
+		return file
+	}
+
+	recipients, err := readRecipientsCSV(write("ok.csv", "# payroll\n"+alice+", 1\n"+bob+",0.25\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []blockchain.Recipient{
+		{Address: alice, Amount: blockchain.Coin},
+		{Address: bob, Amount: blockchain.Coin / 4},
+	}, recipients)
+
+	_, err = readRecipientsCSV(write("amount.csv", alice+",1\n"+bob+",lots\n"))
+	assert.ErrorContains(t, err, "amount.csv:2: bad amount")
+
+	_, err = readRecipientsCSV(write("fields.csv", alice+",1,extra\n"))
+	assert.Error(t, err)
+
+	_, err = readRecipientsCSV(filepath.Join(dir, "missing.csv"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"blockchain/pkg/blockchain"
+	"blockchain/pkg/utils"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// NOTE writes the UTXO set of the node to `out`, together with its hash
+func (cli *CommandLine) dumpTxOutSet(out, nodeId string) {
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	file, err := os.Create(out)
+	utils.DisplayErr(err)
+	defer file.Close()
+
+	header, err := UTXOSet.DumpSnapshot(file)
+	utils.DisplayErr(err)
+
+	fmt.Printf("Wrote %d outputs at height %d to %s\n", header.Outputs, header.Height, out)
+	fmt.Printf("Set hash: %x\n", header.Hash)
+}
+
+// NOTE starts a fresh node from a snapshot, `startnode` then downloads the history
+// NOTE below it and validates the snapshot against it. `hash` is the set hash printed by
+// NOTE dumptxoutset on a node the operator trusts
+func (cli *CommandLine) loadTxOutSet(in, hash, nodeId string) {
+	expected, err := hex.DecodeString(hash)
+	if err != nil || len(expected) == 0 {
+		utils.DisplayErr("Set hash is not valid")
+	}
+
+	file, err := os.Open(in)
+	utils.DisplayErr(err)
+	defer file.Close()
+
+	chain, header, err := blockchain.LoadSnapshot(nodeId, file, expected)
+	utils.DisplayErr(err)
+	defer chain.Database.Close()
+
+	fmt.Printf("Loaded %d outputs at height %d, block %x\n", header.Outputs, header.Height, header.BestBlock)
+	fmt.Printf("Set hash: %x\n", header.Hash)
+}
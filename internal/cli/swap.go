@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"blockchain/pkg/blockchain"
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/network"
+	"blockchain/pkg/utils"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NOTE contract output goes first in NewHTLCTransaction, change follows
+const contractOut = 0
+
+var errContractRef = errors.New("contract must look like TXID:VOUT")
+
+// NOTE swapinitiate: picks the secret and locks coins for the participant.
+// NOTE The secret is printed once, keep it until the redeem on the other chain
+func (cli *CommandLine) swapInitiate(from, to string, amount blockchain.Amount, blocks int, nodeId string, mineNow bool) {
+	secret, hash := blockchain.NewSwapSecret()
+
+	contract := cli.lockSwap(from, to, amount, hash, blocks, nodeId, mineNow)
+
+	fmt.Printf("Secret:   %x\n", secret)
+	fmt.Printf("Hash:     %x\n", hash)
+	fmt.Printf("Contract: %s\n", contract)
+}
+
+// NOTE swapparticipate: locks coins on the second chain under the initiator's hash
+func (cli *CommandLine) swapParticipate(from, to string, amount blockchain.Amount, hash []byte, blocks int, nodeId string, mineNow bool) {
+	contract := cli.lockSwap(from, to, amount, hash, blocks, nodeId, mineNow)
+
+	fmt.Printf("Contract: %s\n", contract)
+}
+
+func (cli *CommandLine) lockSwap(from, to string, amount blockchain.Amount, hash []byte, blocks int, nodeId string, mineNow bool) string {
+	if !wallet.ValidateAddress(from) || !wallet.ValidateAddress(to) {
+		utils.DisplayErr("Address is not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	wallets, err := wallet.CreateWallets(nodeId)
+	utils.DisplayErr(err)
+	w := wallets.GetWallet(from)
+
+	// NOTE timeout is relative to the current tip, the contract is refundable `blocks` blocks later
+	height, _ := chain.GetBestHeightAndLastHash()
+	timeout := height + blocks
+
+	tx := blockchain.NewHTLCTransaction(w, to, amount, hash, timeout, &UTXOSet)
+	submitTx(chain, &UTXOSet, tx, from, mineNow)
+
+	fmt.Printf("Refundable from height %d\n", timeout)
+
+	return fmt.Sprintf("%x:%d", tx.ID, contractOut)
+}
+
+// NOTE swapredeem: the recipient takes the coins with the secret
+func (cli *CommandLine) swapRedeem(contractRef, address string, secret []byte, nodeId string, mineNow bool) {
+	cli.spendSwap(contractRef, address, nodeId, mineNow, func(w *wallet.Wallet, id []byte, out int, contract blockchain.TXO) (*blockchain.Transaction, error) {
+		return blockchain.NewHTLCRedeemTransaction(w, id, out, contract, secret)
+	})
+}
+
+// NOTE swaprefund: the initiator takes the coins back after the timeout
+func (cli *CommandLine) swapRefund(contractRef, address, nodeId string, mineNow bool) {
+	cli.spendSwap(contractRef, address, nodeId, mineNow, blockchain.NewHTLCRefundTransaction)
+}
+
+func (cli *CommandLine) spendSwap(
+	contractRef, address, nodeId string,
+	mineNow bool,
+	build func(w *wallet.Wallet, id []byte, out int, contract blockchain.TXO) (*blockchain.Transaction, error),
+) {
+	if !wallet.ValidateAddress(address) {
+		utils.DisplayErr("Address is not valid")
+	}
+
+	id, out, err := parseContractRef(contractRef)
+	utils.DisplayErr(err)
+
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	contractTx, err := chain.FindTransaction(id)
+	utils.DisplayErr(err)
+	if out >= len(contractTx.Output) {
+		utils.DisplayErr("Contract is not found")
+	}
+
+	wallets, err := wallet.CreateWallets(nodeId)
+	utils.DisplayErr(err)
+	w := wallets.GetWallet(address)
+
+	tx, err := build(w, id, out, contractTx.Output[out])
+	utils.DisplayErr(err)
+
+	submitTx(chain, &UTXOSet, tx, address, mineNow)
+
+	fmt.Printf("Spent contract %s in %x\n", contractRef, tx.ID)
+}
+
+// NOTE swapsecret: the participant reads the secret revealed by the initiator's redeem
+func (cli *CommandLine) swapSecret(contractRef, nodeId string) {
+	id, out, err := parseContractRef(contractRef)
+	utils.DisplayErr(err)
+
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+
+	secret, err := chain.FindHTLCSecret(id, out)
+	utils.DisplayErr(err)
+
+	fmt.Printf("Secret: %x\n", secret)
+}
+
+// NOTE same as in `send`: either mine here, rewarding `miner`, or hand the transaction to the network
+func submitTx(chain *blockchain.Blockchain, UTXOSet *blockchain.UnspentTransactionSET, tx *blockchain.Transaction, miner string, mineNow bool) {
+	if mineNow {
+		cbTx := blockchain.CoinbaseTx(miner, "")
+		block := chain.MineBlock([]*blockchain.Transaction{cbTx, tx})
+		utils.DisplayErr(UTXOSet.Update(block))
+	} else {
+		network.SendTx(network.KnownNodes[0], tx)
+		fmt.Println("send tx")
+	}
+}
+
+func parseContractRef(ref string) ([]byte, int, error) {
+	txid, vout, found := strings.Cut(ref, ":")
+	if !found {
+		return nil, 0, fmt.Errorf("%w: %q", errContractRef, ref)
+	}
+
+	id, err := hex.DecodeString(txid)
+	if err != nil || len(id) == 0 {
+		return nil, 0, fmt.Errorf("%w: %q", errContractRef, ref)
+	}
+
+	out, err := strconv.Atoi(vout)
+	if err != nil || out < 0 {
+		return nil, 0, fmt.Errorf("%w: %q", errContractRef, ref)
+	}
+
+	return id, out, nil
+}
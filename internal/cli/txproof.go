@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"blockchain/pkg/blockchain"
+	"blockchain/pkg/utils"
+	"encoding/hex"
+	"fmt"
+)
+
+// NOTE prints the inclusion proof of a transaction as hex, for whoever has to check it
+func (cli *CommandLine) getTxProof(txId, nodeId string) {
+	txID, err := hex.DecodeString(txId)
+	utils.DisplayErr(err)
+
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+
+	proof, err := chain.TxProof(txID)
+	utils.DisplayErr(err)
+
+	fmt.Printf("Block %x at height %d, transaction %d\n", proof.Header.Hash, proof.Header.Height, proof.Index)
+	fmt.Printf("%x\n", proof.Serialize())
+}
+
+// NOTE checks a proof made by gettxproof against the headers of this node
+func (cli *CommandLine) verifyTxProof(proofHex, nodeId string) {
+	data, err := hex.DecodeString(proofHex)
+	utils.DisplayErr(err)
+
+	proof, err := blockchain.DeserializeTxProof(data)
+	utils.DisplayErr(err)
+
+	chain := blockchain.ContinueBlockchain(nodeId)
+	defer chain.Database.Close()
+
+	utils.DisplayErr(chain.VerifyTxProof(proof))
+
+	tip, _ := chain.GetBestHeightAndLastHash()
+
+	fmt.Println(blockchain.DeserializeTransaction(proof.Transaction))
+	fmt.Printf("Included in block %x at height %d, %d confirmations\n", proof.Header.Hash, proof.Header.Height, tip-proof.Header.Height+1)
+}
@@ -0,0 +1,273 @@
+// NOTE optional address index, so wallets don't scan the whole UTXO set or walk the chain:
+// NOTE 	addru- + pubkey hash + txid + vout   -> UnspentOutput, outputs the key can spend
+// NOTE 	addrt- + pubkey hash + height + txid -> nothing, confirmed transactions touching the key
+// NOTE Transactions touch the keys of their inputs (PubKey) and the owners of their outputs,
+// NOTE including both sides of hash time locks and channels. Update and Disconnect keep
+// NOTE the index in step with the set once it is enabled
+
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/utils"
+	"encoding/binary"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	addrIndexKey      = []byte("addrindex")
+	addrUnspentPrefix = []byte("addru-")
+	addrTxPrefix      = []byte("addrt-")
+)
+
+// NOTE confirmed transaction of an address
+type AddressTransaction struct {
+	TxID   []byte
+	Height int
+}
+
+func addrUnspentKey(pubKeyHash, txID []byte, out int) []byte {
+	key := make([]byte, 0, len(addrUnspentPrefix)+len(pubKeyHash)+len(txID)+4)
+	key = append(key, addrUnspentPrefix...)
+	key = append(key, pubKeyHash...)
+	key = append(key, txID...)
+
+	return binary.BigEndian.AppendUint32(key, uint32(out))
+}
+
+// NOTE height goes before txid, so history comes out in chain order
+func addrTxKey(pubKeyHash []byte, height int, txID []byte) []byte {
+	key := make([]byte, 0, len(addrTxPrefix)+len(pubKeyHash)+4+len(txID))
+	key = append(key, addrTxPrefix...)
+	key = append(key, pubKeyHash...)
+	key = binary.BigEndian.AppendUint32(key, uint32(height))
+
+	return append(key, txID...)
+}
+
+func addressPrefix(prefix, pubKeyHash []byte) []byte {
+	return append(append([]byte{}, prefix...), pubKeyHash...)
+}
+
+// NOTE pubkey hashes which can spend the output alone or as one of the parties
+func (out *TXO) owners() [][]byte {
+	switch {
+	case out.HTLC != nil:
+		return [][]byte{out.HTLC.Recipient, out.HTLC.Refund}
+	case out.Channel != nil:
+		return [][]byte{wallet.PublicKey(out.Channel.Payer), wallet.PublicKey(out.Channel.Payee)}
+	case len(out.PubkeyHash) > 0:
+		return [][]byte{out.PubkeyHash}
+	}
+
+	return nil
+}
+
+// NOTE every pubkey hash the transaction touches, each once
+func (tx *Transaction) addresses() [][]byte {
+	var (
+		found [][]byte
+		seen  = make(map[string]bool)
+	)
+
+	add := func(pubKeyHash []byte) {
+		if len(pubKeyHash) > 0 && !seen[string(pubKeyHash)] {
+			seen[string(pubKeyHash)] = true
+			found = append(found, pubKeyHash)
+		}
+	}
+
+	if !tx.IsCoinbase() {
+		for _, in := range tx.Inputs {
+			if len(in.PubKey) > 0 {
+				add(wallet.PublicKey(in.PubKey))
+			}
+		}
+	}
+
+	for _, out := range tx.Output {
+		for _, owner := range out.owners() {
+			add(owner)
+		}
+	}
+
+	return found
+}
+
+// NOTE only plain outputs are indexed as unspent, locked ones need more than a key
+func indexUnspent(txn kvWriter, txID []byte, out int, unspent UnspentOutput) error {
+	if len(unspent.Output.PubkeyHash) == 0 {
+		return nil
+	}
+
+	return txn.Set(addrUnspentKey(unspent.Output.PubkeyHash, txID, out), unspent.Serialize())
+}
+
+func unindexUnspent(txn kvWriter, txID []byte, out int, unspent UnspentOutput) error {
+	if len(unspent.Output.PubkeyHash) == 0 {
+		return nil
+	}
+
+	return txn.Delete(addrUnspentKey(unspent.Output.PubkeyHash, txID, out))
+}
+
+func indexTransaction(txn kvWriter, tx *Transaction, height int) error {
+	for _, pubKeyHash := range tx.addresses() {
+		if err := txn.Set(addrTxKey(pubKeyHash, height, tx.ID), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unindexTransaction(txn kvWriter, tx *Transaction, height int) error {
+	for _, pubKeyHash := range tx.addresses() {
+		if err := txn.Delete(addrTxKey(pubKeyHash, height, tx.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u UnspentTransactionSET) AddressIndexEnabled() bool {
+	enabled := false
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(addrIndexKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+
+		enabled = err == nil
+		return err
+	})
+	utils.DisplayErr(err)
+
+	return enabled
+}
+
+// NOTE EnableAddressIndex builds the index, from then on it is kept up to date
+func (u *UnspentTransactionSET) EnableAddressIndex() {
+	err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(addrIndexKey, []byte{1})
+	})
+	utils.DisplayErr(err)
+
+	u.ReindexAddresses()
+}
+
+// NOTE ReindexAddresses rebuilds the index: unspent part from the set, history from the chain
+func (u *UnspentTransactionSET) ReindexAddresses() {
+	u.DeleteUnspent(addrUnspentPrefix)
+	u.DeleteUnspent(addrTxPrefix)
+
+	// NOTE the index of a big set doesn't fit in one transaction
+	batch := u.Blockchain.Database.NewWriteBatch()
+
+	var err error
+	u.forEachOutput(func(txID []byte, out int, unspent UnspentOutput) {
+		if err == nil {
+			err = indexUnspent(batch, txID, out, unspent)
+		}
+	})
+
+	iter := u.Blockchain.Iterator()
+
+	for err == nil {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			if err = indexTransaction(batch, tx, block.Height); err != nil {
+				break
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	if err != nil {
+		batch.Cancel()
+		utils.DisplayErr(err)
+	}
+
+	utils.DisplayErr(batch.Flush())
+}
+
+// NOTE AddressUnspent lists the plain outputs locked to pubKeyHash, index must be enabled
+func (u UnspentTransactionSET) AddressUnspent(pubKeyHash []byte) []SpendableOutput {
+	var spendable []SpendableOutput
+
+	prefix := addressPrefix(addrUnspentPrefix, pubKeyHash)
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().KeyCopy(nil)
+
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			unspent := DeserializeUnspentOutput(v)
+			txID := key[len(prefix) : len(key)-4]
+			out := int(binary.BigEndian.Uint32(key[len(key)-4:]))
+
+			spendable = append(spendable, SpendableOutput{TxID: txID, Out: out, Output: unspent.Output, Height: unspent.Height})
+		}
+
+		return nil
+	})
+	utils.DisplayErr(err)
+
+	return spendable
+}
+
+// NOTE AddressTransactions lists confirmed transactions touching pubKeyHash, oldest first
+func (u UnspentTransactionSET) AddressTransactions(pubKeyHash []byte) []AddressTransaction {
+	var history []AddressTransaction
+
+	prefix := addressPrefix(addrTxPrefix, pubKeyHash)
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			height := int(binary.BigEndian.Uint32(key[len(prefix) : len(prefix)+4]))
+
+			history = append(history, AddressTransaction{TxID: key[len(prefix)+4:], Height: height})
+		}
+
+		return nil
+	})
+	utils.DisplayErr(err)
+
+	return history
+}
+
+// NOTE Balances sums unspent outputs locked to pubKeyHash by asset, "" is the native coin.
+// NOTE Reads the address index when it is enabled, scans the set otherwise
+func (u UnspentTransactionSET) Balances(pubKeyHash []byte) (map[string]Amount, error) {
+	if !u.AddressIndexEnabled() {
+		return sumByAsset(u.FindUnspentTransactions(pubKeyHash))
+	}
+
+	var outs []TXO
+	for _, out := range u.AddressUnspent(pubKeyHash) {
+		outs = append(outs, out.Output)
+	}
+
+	return sumByAsset(outs)
+}
@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressIndex(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW := wallets[0]
+	alicePKH, bobPKH := wallet.AddressPubKeyHash(alice), wallet.AddressPubKeyHash(bob)
+
+	chain, UTXO := newTestChain(t, "addrindex", alice)
+
+	pay := NewTransaction(aliceW, bob, 5*Coin, UTXO)
+	mineTestBlock(chain, UTXO, alice, pay)
+
+	// NOTE the index is built from what is already there
+	assert.False(t, UTXO.AddressIndexEnabled())
+	UTXO.EnableAddressIndex()
+	assert.True(t, UTXO.AddressIndexEnabled())
+
+	assert.Len(t, UTXO.AddressTransactions(alicePKH), 3)
+	assert.Len(t, UTXO.AddressTransactions(bobPKH), 1)
+
+	before := UTXO.AddressUnspent(alicePKH)
+
+	// NOTE ... and kept up to date by Update
+	second := NewTransaction(aliceW, bob, 7*Coin, UTXO)
+	block := chain.MineBlock([]*Transaction{CoinbaseTx(alice, ""), second})
+	assert.NoError(t, UTXO.Update(block))
+
+	balances, err := UTXO.Balances(bobPKH)
+	assert.NoError(t, err)
+	assert.Equal(t, 12*Coin, balances[""])
+	assert.Len(t, UTXO.AddressUnspent(bobPKH), 2)
+
+	history := UTXO.AddressTransactions(bobPKH)
+	assert.Len(t, history, 2)
+	assert.Equal(t, pay.ID, history[0].TxID)
+	assert.Equal(t, second.ID, history[1].TxID)
+	assert.Equal(t, 2, history[1].Height)
+
+	// NOTE index agrees with a full rebuild
+	indexed := UTXO.AddressUnspent(alicePKH)
+	UTXO.Reindex()
+	assert.ElementsMatch(t, indexed, UTXO.AddressUnspent(alicePKH))
+
+	// NOTE Disconnect takes the block back out of the set and the index
+	assert.NoError(t, UTXO.Disconnect(block))
+	assert.ElementsMatch(t, before, UTXO.AddressUnspent(alicePKH))
+	assert.Len(t, UTXO.AddressUnspent(bobPKH), 1)
+	assert.Len(t, UTXO.AddressTransactions(bobPKH), 1)
+	assert.Len(t, UTXO.AddressTransactions(alicePKH), 3)
+
+	_, found := UTXO.Lookup(second.ID, 0)
+	assert.False(t, found)
+	for _, in := range second.Inputs {
+		_, found := UTXO.Lookup(in.ID, in.Out)
+		assert.True(t, found)
+	}
+
+	// NOTE undo data is used once
+	assert.ErrorIs(t, UTXO.Disconnect(block), errNoUndo)
+}
@@ -0,0 +1,155 @@
+// NOTE amounts are kept in base units, like satoshis: one coin is 10^AmountDecimals units.
+// NOTE No floats anywhere, "12.345" is parsed digit by digit, and every sum which
+// NOTE reaches consensus goes through Add/Sub, so an overflow is an error instead of
+// NOTE a silently wrapped (and suddenly negative) balance
+
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Amount int64
+
+const (
+	AmountDecimals = 8
+
+	Coin Amount = 100000000
+	// NOTE upper bound of any single value or sum, well below int64 overflow
+	MaxAmount Amount = 21000000 * Coin
+	// NOTE mining reward paid by CoinbaseTx
+	Subsidy Amount = 20 * Coin
+)
+
+var (
+	ErrNegativeAmount = errors.New("amount is negative")
+	ErrAmountOverflow = errors.New("amount exceeds the maximum")
+	errAmountFormat   = errors.New("malformed amount")
+)
+
+// NOTE Validate accepts 0..MaxAmount
+func (a Amount) Validate() error {
+	if a < 0 {
+		return ErrNegativeAmount
+	}
+	if a > MaxAmount {
+		return ErrAmountOverflow
+	}
+
+	return nil
+}
+
+// NOTE checked a + b, both sides and the result must be valid amounts
+func (a Amount) Add(b Amount) (Amount, error) {
+	if err := a.Validate(); err != nil {
+		return 0, err
+	}
+	if err := b.Validate(); err != nil {
+		return 0, err
+	}
+
+	sum := a + b
+
+	return sum, sum.Validate()
+}
+
+// NOTE checked a - b, the result can't go below zero
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if err := a.Validate(); err != nil {
+		return 0, err
+	}
+	if err := b.Validate(); err != nil {
+		return 0, err
+	}
+	if b > a {
+		return 0, ErrNegativeAmount
+	}
+
+	return a - b, nil
+}
+
+// NOTE SumAmounts adds everything up with overflow checks
+func SumAmounts(amounts ...Amount) (Amount, error) {
+	var total Amount
+
+	for _, a := range amounts {
+		var err error
+		if total, err = total.Add(a); err != nil {
+			return 0, err
+		}
+	}
+
+	return total, nil
+}
+
+// NOTE ParseAmount reads "12", "12.345" or ".5" into base units
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if (whole == "" && frac == "") || len(frac) > AmountDecimals {
+		return 0, fmt.Errorf("%w: %q", errAmountFormat, s)
+	}
+
+	for _, part := range []string{whole, frac} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, fmt.Errorf("%w: %q", errAmountFormat, s)
+			}
+		}
+	}
+
+	var units uint64
+
+	if whole != "" {
+		w, err := strconv.ParseUint(whole, 10, 64)
+		if err != nil || w > uint64(MaxAmount/Coin) {
+			return 0, fmt.Errorf("%w: %q", ErrAmountOverflow, s)
+		}
+		units = w * uint64(Coin)
+	}
+
+	if frac != "" {
+		frac += strings.Repeat("0", AmountDecimals-len(frac))
+
+		f, err := strconv.ParseUint(frac, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", errAmountFormat, s)
+		}
+		units += f
+	}
+
+	if units > math.MaxInt64 {
+		return 0, fmt.Errorf("%w: %q", ErrAmountOverflow, s)
+	}
+
+	amount := Amount(units)
+
+	return amount, amount.Validate()
+}
+
+// NOTE String prints coins with trailing zeros of the fraction cut off, e.g. "12.345"
+func (a Amount) String() string {
+	sign := ""
+	units := uint64(a)
+
+	if a < 0 {
+		sign = "-"
+		units = uint64(-(a + 1)) + 1
+	}
+
+	whole := units / uint64(Coin)
+	frac := units % uint64(Coin)
+
+	if frac == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+
+	fraction := strings.TrimRight(fmt.Sprintf("%0*d", AmountDecimals, frac), "0")
+
+	return fmt.Sprintf("%s%d.%s", sign, whole, fraction)
+}
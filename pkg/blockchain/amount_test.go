@@ -0,0 +1,54 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAmount(t *testing.T) {
+	valid := map[string]Amount{
+		"0":          0,
+		"12":         12 * Coin,
+		"12.345":     12*Coin + 34500000,
+		".5":         Coin / 2,
+		"0.00000001": 1,
+		"21000000":   MaxAmount,
+	}
+
+	for s, expected := range valid {
+		amount, err := ParseAmount(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, amount, s)
+	}
+
+	for _, s := range []string{"", ".", "-1", "1.123456789", "1e5", "21000000.00000001", "99999999999999999999"} {
+		_, err := ParseAmount(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	assert.Equal(t, "12.345", (12*Coin + 34500000).String())
+	assert.Equal(t, "20", Subsidy.String())
+	assert.Equal(t, "0.00000001", Amount(1).String())
+	assert.Equal(t, "-1.5", (-Coin - Coin/2).String())
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	sum, err := Amount(5).Add(7)
+	assert.NoError(t, err)
+	assert.Equal(t, Amount(12), sum)
+
+	_, err = MaxAmount.Add(1)
+	assert.ErrorIs(t, err, ErrAmountOverflow)
+
+	_, err = Amount(5).Sub(7)
+	assert.ErrorIs(t, err, ErrNegativeAmount)
+
+	_, err = Amount(-1).Add(1)
+	assert.ErrorIs(t, err, ErrNegativeAmount)
+
+	_, err = SumAmounts(MaxAmount/2, MaxAmount/2, MaxAmount/2)
+	assert.ErrorIs(t, err, ErrAmountOverflow)
+}
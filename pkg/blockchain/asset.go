@@ -0,0 +1,167 @@
+// NOTE assets (colored outputs) - units other than the native coin, e.g. labor hours or shares.
+// NOTE An output carries the asset ID next to its value, nil means the native coin.
+// NOTE Issuance is a transaction with `Issue` set, spending at least one output of the issuer,
+// NOTE so the issuer's signature authorizes it. It may create any amount of its asset,
+// NOTE every other transaction must move each asset 1:1 from inputs to outputs
+// NOTE (the native coin keeps its own rule: outputs must not exceed inputs)
+
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/sha"
+	"blockchain/pkg/utils"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	MaxAssetNameSize = 32
+	assetIDSize      = 32
+)
+
+var (
+	errAssetName    = errors.New("asset name must be 1 to 32 bytes")
+	errAssetID      = errors.New("malformed asset id")
+	errAssetOutput  = errors.New("only plain outputs can carry assets")
+	errAssetIssuer  = errors.New("issuance is not signed by the issuer")
+	errAssetBalance = errors.New("asset inputs and outputs differ")
+)
+
+// NOTE issuance of the asset AssetID(Issuer, Name)
+type AssetIssuance struct {
+	Name string
+	// NOTE pubkey hash of the issuer, one of the inputs must be locked to it
+	Issuer []byte
+}
+
+// NOTE AssetID is the SHA-256 of the issuer's pubkey hash and the asset name,
+// NOTE nobody else can issue units of the same asset
+func AssetID(issuer []byte, name string) []byte {
+	hash := sha.ComputeHash(bytes.Join([][]byte{issuer, []byte(name)}, []byte{}))
+
+	return hash[:]
+}
+
+func (i *AssetIssuance) AssetID() []byte {
+	return AssetID(i.Issuer, i.Name)
+}
+
+func (i *AssetIssuance) check() error {
+	if len(i.Name) == 0 || len(i.Name) > MaxAssetNameSize {
+		return errAssetName
+	}
+	if len(i.Issuer) == 0 {
+		return errAssetIssuer
+	}
+
+	return nil
+}
+
+func (out *TXO) IsAsset() bool {
+	return len(out.Asset) > 0
+}
+
+func (out *TXO) checkAsset() error {
+	if len(out.Asset) != assetIDSize {
+		return errAssetID
+	}
+	if out.IsDataCarrier() || out.HTLC != nil || out.Channel != nil {
+		return errAssetOutput
+	}
+
+	return nil
+}
+
+// NOTE NewAssetTXO locks `value` units of `asset` to the address
+func NewAssetTXO(value Amount, asset []byte, address string) *TXO {
+	txo := NewTXO(value, address)
+	if len(asset) > 0 {
+		txo.Asset = asset
+	}
+
+	return txo
+}
+
+// NOTE NewIssueTransaction issues `amount` units of the wallet's asset `name` to `to`.
+// NOTE The native input which authorizes the issuance comes back as change
+func NewIssueTransaction(w *wallet.Wallet, name string, amount Amount, to string, UTXO *UnspentTransactionSET) *Transaction {
+	issue := &AssetIssuance{Name: name, Issuer: wallet.PublicKey(w.PublicKey)}
+	if err := issue.check(); err != nil {
+		utils.DisplayErr(err)
+	}
+
+	outputs := []TXO{*NewAssetTXO(amount, issue.AssetID(), to)}
+
+	return buildTransaction(w, outputs, nil, issue, UTXO)
+}
+
+// NOTE per asset sums, keyed by hex asset ID ("" is the native coin)
+func sumByAsset(outs []TXO) (map[string]Amount, error) {
+	sums := make(map[string]Amount)
+
+	for _, out := range outs {
+		key := hex.EncodeToString(out.Asset)
+
+		sum, err := sums[key].Add(out.Value)
+		if err != nil {
+			return nil, err
+		}
+		sums[key] = sum
+	}
+
+	return sums, nil
+}
+
+// NOTE asset keys in a stable order, the native coin first
+func sortedAssets(sums map[string]Amount) []string {
+	keys := make([]string, 0, len(sums))
+	for key := range sums {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// NOTE conservation rules of checkValueBalance, sums are already overflow checked
+func checkAssetBalance(tx *Transaction, prevOuts []TXO, ins, outs map[string]Amount) error {
+	issued := ""
+
+	if tx.Issue != nil {
+		issued = hex.EncodeToString(tx.Issue.AssetID())
+
+		authorized := false
+		for _, prevOut := range prevOuts {
+			if prevOut.IsLockedWithKey(tx.Issue.Issuer) {
+				authorized = true
+				break
+			}
+		}
+
+		if !authorized {
+			return fmt.Errorf("%w: transaction %x", errAssetIssuer, tx.ID)
+		}
+	}
+
+	for _, key := range sortedAssets(outs) {
+		if key == "" || key == issued {
+			continue
+		}
+
+		if outs[key] != ins[key] {
+			return fmt.Errorf("%w: transaction %x, asset %s, %s != %s", errAssetBalance, tx.ID, key, outs[key], ins[key])
+		}
+	}
+
+	for _, key := range sortedAssets(ins) {
+		if _, ok := outs[key]; !ok && key != "" && key != issued && ins[key] > 0 {
+			return fmt.Errorf("%w: transaction %x, asset %s is not spent to any output", errAssetBalance, tx.ID, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssetIssuance(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	issuer, bob := addresses[0], addresses[1]
+	issuerW, bobW := wallets[0], wallets[1]
+
+	chain, UTXO := newTestChain(t, "asset", issuer)
+
+	issue := NewIssueTransaction(issuerW, "hours", 100*Coin, issuer, UTXO)
+	mineTestBlock(chain, UTXO, issuer, issue)
+
+	hours := AssetID(wallet.AddressPubKeyHash(issuer), "hours")
+	key := hex.EncodeToString(hours)
+
+	balances, err := UTXO.Balances(wallet.AddressPubKeyHash(issuer))
+	assert.NoError(t, err)
+	assert.Equal(t, 100*Coin, balances[key])
+	assert.Equal(t, 2*Subsidy, balances[""])
+
+	pay := NewBatchTransaction(issuerW, []Recipient{{Address: bob, Amount: 30 * Coin, Asset: hours}}, nil, UTXO)
+	mineTestBlock(chain, UTXO, issuer, pay)
+
+	// NOTE native payments leave asset outputs alone
+	coins := NewTransaction(issuerW, bob, 5*Coin, UTXO)
+	mineTestBlock(chain, UTXO, issuer, coins)
+
+	balances, err = UTXO.Balances(wallet.AddressPubKeyHash(issuer))
+	assert.NoError(t, err)
+	assert.Equal(t, 70*Coin, balances[key])
+	assert.Equal(t, 4*Subsidy-5*Coin, balances[""])
+
+	balances, err = UTXO.Balances(wallet.AddressPubKeyHash(bob))
+	assert.NoError(t, err)
+	assert.Equal(t, 30*Coin, balances[key])
+	assert.Equal(t, 5*Coin, balances[""])
+
+	// NOTE bob can't print issuer's hours, nor inflate the ones he has
+	bobHours := TXI{ID: pay.ID, Out: 0, PubKey: bobW.PublicKey}
+
+	forged := Transaction{
+		Inputs: []TXI{bobHours},
+		Output: []TXO{*NewAssetTXO(1000*Coin, hours, bob)},
+		Issue:  &AssetIssuance{Name: "hours", Issuer: wallet.AddressPubKeyHash(issuer)},
+	}
+	forged.ID = forged.Hash()
+	chain.SignTransaction(&forged, bobW.PrivateKey)
+	assert.False(t, chain.VerifyTransaction(&forged))
+
+	inflated := Transaction{
+		Inputs: []TXI{bobHours},
+		Output: []TXO{*NewAssetTXO(40*Coin, hours, bob)},
+	}
+	inflated.ID = inflated.Hash()
+	chain.SignTransaction(&inflated, bobW.PrivateKey)
+	assert.False(t, chain.VerifyTransaction(&inflated))
+
+	moved := Transaction{
+		Inputs: []TXI{bobHours},
+		Output: []TXO{*NewAssetTXO(10*Coin, hours, issuer), *NewAssetTXO(20*Coin, hours, bob)},
+	}
+	moved.ID = moved.Hash()
+	chain.SignTransaction(&moved, bobW.PrivateKey)
+	assert.True(t, chain.VerifyTransaction(&moved))
+}
@@ -5,12 +5,19 @@ import (
 	"blockchain/pkg/utils"
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"time"
 )
 
 var info = logging.Info
 var errMsg = logging.Error
 
+var (
+	errBlockMerkleRoot  = errors.New("block transactions don't give its Merkle root")
+	errBlockDuplicateTx = errors.New("block holds a transaction twice")
+)
+
 // NOTE each block contains huge number of transaction, to be created
 type Block struct {
 	Timestamp    int64
@@ -18,23 +25,44 @@ type Block struct {
 	Transactions []*Transaction
 	PrevHash     []byte
 	Height       int // required for main - SPV comparison
+	// NOTE root of the Merkle tree of Transactions, part of the proof of work,
+	// NOTE so the header alone proves what the block holds (see txproof.go)
+	MerkleRoot []byte
 	// NOTE field that indicates the "difficulty"
 	Nonce int
 }
 
 // NOTE we hash each transaction of the block, transaction ID
 func (b *Block) HashTransactions() []byte {
-	var hashes [][]byte
+	// NOTE the root only, the tree is built when a proof is asked for
+	return MerkleRoot(len(b.Transactions), func(i int) []byte {
+		return b.Transactions[i].Serialize()
+	})
+}
+
+// NOTE Check tells whether the block is what its header commits to: the proof of work
+// NOTE is valid and the transactions give the Merkle root. A peer could send a valid
+// NOTE header with other transactions otherwise
+func (b *Block) Check() error {
+	if !NewProof(b).Validate() {
+		return errHeaderPoW
+	}
+
+	if !bytes.Equal(b.HashTransactions(), b.MerkleRoot) {
+		return errBlockMerkleRoot
+	}
 
+	// NOTE odd levels duplicate their last node, so [a,b,c] and [a,b,c,c] share a root
+	// NOTE (CVE-2012-2459). Prefixes don't help there, a repeated transaction does
+	seen := make(map[string]bool, len(b.Transactions))
 	for _, tx := range b.Transactions {
-		hashes = append(hashes, tx.Serialize())
+		if seen[string(tx.ID)] {
+			return fmt.Errorf("%w: %x", errBlockDuplicateTx, tx.ID)
+		}
+		seen[string(tx.ID)] = true
 	}
-	// Convert serialized transactions within the block
-	// and convert them into tree
-	tree := NewMerkleTree(hashes)
 
-	// Create a merkle tree
-	return tree.RootNode.Data
+	return nil
 }
 
 // NOTE CreateBlock generates a new block with provided data and previous hash.
@@ -49,6 +77,7 @@ func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
 		Timestamp: time.Now().Unix(),
 		Height:    height,
 	}
+	block.MerkleRoot = block.HashTransactions()
 
 	pow := NewProof(block)
 	nonce, hash := pow.Run()
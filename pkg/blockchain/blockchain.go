@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -26,25 +27,34 @@ type (
 	BlockchainIterator struct {
 		CurrentHash []byte
 		Database    *badger.DB
+		// NOTE a snapshot is loaded and its history not validated yet, see Next
+		snapshot bool
 	}
 )
 
 var (
 	dbPath      = "/home/guts/repos/bc/tmp/blocks_%s/"
 	genesisData = os.Getenv("genesisData")
+
+	// NOTE format of what the stored blocks mean, see chainFormat
+	chainFormatKey = []byte("chainformat")
+
+	errChainFormat = errors.New("blockchain database of another format, delete it and sync again")
 )
 
+// NOTE blocks can't be rebuilt like the UTXO set, a database of another format is refused.
+// NOTE Databases without the key predate it
+// NOTE 1 - output values in Amount base units, 10^8 per coin, they used to count whole coins
+// NOTE 2 - proof of work over the Merkle root, leaves and inner nodes hashed with prefixes
+// NOTE 3 - transaction IDs leave out the public keys of the inputs
+const chainFormat = 3
+
 func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
 	iter := bc.Iterator()
 
 	for {
 		block := iter.Next()
 
-		// NOTE if we reach the last block
-		if len(block.PrevHash) == 0 {
-			break
-		}
-
 		// NOTE if we found the transaction within a block, which id
 		// NOTE matches the settled ID - win-win
 		for _, tx := range block.Transactions {
@@ -52,12 +62,28 @@ func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
 				return *tx, nil
 			}
 		}
+
+		// NOTE if we reach the last block, genesis is checked as well
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	// NOTE transactions of pruned blocks, see prune.go
+	if tx, ok := bc.transactionFromSet(ID); ok {
+		return tx, nil
 	}
 
 	return Transaction{}, nil
 }
 
 func (b *Blockchain) SignTransaction(t *Transaction, privateKey ecdsa.PrivateKey) {
+	b.SignTransactionWithHashType(t, privateKey, SigHashAll)
+}
+
+// NOTE sign with explicit sighash flags, e.g. SigHashAll|SigHashAnyoneCanPay when
+// NOTE several parties add their own inputs to one shared transaction
+func (b *Blockchain) SignTransactionWithHashType(t *Transaction, privateKey ecdsa.PrivateKey, hashType SigHashType) {
 	prevTs := make(map[string]Transaction)
 
 	for _, in := range t.Inputs {
@@ -67,25 +93,22 @@ func (b *Blockchain) SignTransaction(t *Transaction, privateKey ecdsa.PrivateKey
 		prevTs[hex.EncodeToString(prevT.ID)] = prevT
 	}
 
-	t.Sign(privateKey, prevTs)
+	t.SignWithHashType(privateKey, prevTs, hashType)
 }
 func (b *Blockchain) VerifyTransaction(t *Transaction) bool {
 	if t.IsCoinbase() {
 		return true
 	}
 
-	prevTs := make(map[string]Transaction)
-
-	// NOTE collect all transactions into hash-table
-	for _, in := range t.Inputs {
-		prevT, err := b.FindTransaction(in.ID)
-		utils.DisplayErr(err)
-
-		prevTs[hex.EncodeToString(prevT.ID)] = prevT
+	// NOTE pool only keeps what can go into the next block
+	if height, _ := b.GetBestHeightAndLastHash(); !t.IsFinal(height + 1) {
+		return false
 	}
 
-	// NOTE send hash-table for verification
-	return t.Verify(prevTs)
+	// NOTE previous outputs come from the unspent set,
+	// NOTE inputs are then verified concurrently. Used for memory pool
+	// NOTE transactions, so valid signatures are kept in SignatureCache
+	return verifyTransactions([]*Transaction{t}, UnspentTransactionSET{Blockchain: b}.Lookup, true) == nil
 }
 
 func ContinueBlockchain(nodeId string) *Blockchain {
@@ -102,6 +125,12 @@ func ContinueBlockchain(nodeId string) *Blockchain {
 	db, err := openDB(path, opts)
 	utils.DisplayErr(err)
 
+	if err := db.View(checkChainFormat); err != nil {
+		db.Close()
+		fmt.Printf("%s: %s\n", path, err)
+		runtime.Goexit()
+	}
+
 	err = db.Update(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("lh"))
 		utils.DisplayErr(err)
@@ -117,6 +146,12 @@ func ContinueBlockchain(nodeId string) *Blockchain {
 
 	chain := Blockchain{lastHash, db}
 
+	// NOTE databases of older versions, or with an unfinished cache flush, get their UTXO set rebuilt once
+	UTXOSet := UnspentTransactionSET{Blockchain: &chain}
+	if UTXOSet.Migrate() {
+		info.Info("UTXO set rebuilt, version %d", utxoVersion)
+	}
+
 	return &chain
 }
 
@@ -140,6 +175,10 @@ func InitBlockchain(address, nodeId string) *Blockchain {
 		fmt.Println("Genesis created")
 		err = txn.Set(genesis.Hash, genesis.Serialize())
 		utils.DisplayErr(err)
+		err = txn.Set(heightKey(0), genesis.Hash)
+		utils.DisplayErr(err)
+		err = setChainFormat(txn)
+		utils.DisplayErr(err)
 		err = txn.Set([]byte("lh"), genesis.Hash)
 
 		lastHash = genesis.Hash
@@ -154,6 +193,31 @@ func InitBlockchain(address, nodeId string) *Blockchain {
 	return &blockchain
 }
 
+func setChainFormat(txn *badger.Txn) error {
+	return txn.Set(chainFormatKey, []byte{chainFormat})
+}
+
+func checkChainFormat(txn *badger.Txn) error {
+	item, err := txn.Get(chainFormatKey)
+	if err == badger.ErrKeyNotFound {
+		return fmt.Errorf("%w: the database has no format, this node uses %d", errChainFormat, chainFormat)
+	}
+	if err != nil {
+		return err
+	}
+
+	format, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+
+	if len(format) != 1 || int(format[0]) != chainFormat {
+		return fmt.Errorf("%w: the database has format %v, this node uses %d", errChainFormat, format, chainFormat)
+	}
+
+	return nil
+}
+
 func (r *Blockchain) SaveBlock(block *Block) error {
 	return r.Database.Update(func(txn *badger.Txn) error {
 		// NOTE if passed block is empty
@@ -356,22 +420,28 @@ func (chain *Blockchain) MineBlock(transaction []*Transaction) *Block {
 		lastHeight int
 	)
 
-	for _, tx := range transaction {
-		if !chain.VerifyTransaction(tx) {
-			errMsg.Error("Invalid Transaction")
-		}
-	}
-
 	// NOTE read transaction to retrieve last block, and then its height(simple integer)
 	height, hash := chain.GetBestHeightAndLastHash()
 
 	lastHeight, lastHash = height, hash
 
+	for _, tx := range transaction {
+		if !tx.IsFinal(lastHeight + 1) {
+			errMsg.Error("Transaction %x is locked until height %d", tx.ID, tx.LockTime)
+		}
+	}
+
+	if err := chain.VerifyTransactions(transaction); err != nil {
+		errMsg.Error("Invalid Transaction: %s", err)
+	}
+
 	newBlock := CreateBlock(transaction, lastHash, lastHeight+1)
 
 	err := chain.Database.Update(func(txn *badger.Txn) error {
 		err := txn.Set(newBlock.Hash, newBlock.Serialize())
 		utils.DisplayErr(err)
+		err = txn.Set(heightKey(newBlock.Height), newBlock.Hash)
+		utils.DisplayErr(err)
 		err = txn.Set([]byte("lh"), newBlock.Hash)
 
 		chain.LastHash = newBlock.Hash
@@ -384,7 +454,19 @@ func (chain *Blockchain) MineBlock(transaction []*Transaction) *Block {
 
 // After adding a network, we must ensure that distributed
 // blocks are the same(valid) with master block
-func (chain *Blockchain) AddBlock(block *Block) {
+// NOTE AddBlock stores a block received from a peer, which is checked first (see Check)
+func (chain *Blockchain) AddBlock(block *Block) error {
+	if err := block.Check(); err != nil {
+		return fmt.Errorf("block %x: %w", block.Hash, err)
+	}
+
+	// NOTE a block building on the unspent set is verified in full before it is stored,
+	// NOTE blocks of a download arriving from the tip back are verified when the set is
+	// NOTE replayed over them (see replay)
+	if err := chain.VerifyBlock(block); err != nil && !errors.Is(err, errSetTip) {
+		return err
+	}
+
 	err := chain.Database.Update(func(txn *badger.Txn) error {
 		// NOTE Check does block exist in DB;
 		// Get looks for key and returns corresponding Item.
@@ -418,53 +500,70 @@ func (chain *Blockchain) AddBlock(block *Block) {
 			err = txn.Set([]byte("lh"), block.Hash)
 			utils.DisplayErr(err)
 			chain.LastHash = block.Hash
+
+			return indexMainChain(txn, block)
 		}
 
-		return nil
-	})
+		// NOTE blocks of the initial download come from the tip back
+		onMainChain, err := extendsMainChainDown(txn, block)
+		if err != nil || !onMainChain {
+			return err
+		}
 
+		return indexMainChain(txn, block)
+	})
 	utils.DisplayErr(err)
+
+	return nil
 }
 
 // TODO we should make a method which will iterate over blockchain transactions
 // TODO and find all unspent outputs from these transactions
 
-func (chain *Blockchain) FindUnspentTransactionsOutputs() map[string]TXOs {
-	var UTXO = make(map[string]TXOs)
+// NOTE FindUnspentOutputs walks the whole chain, result is keyed by hex txid, then by output index
+func (chain *Blockchain) FindUnspentOutputs() map[string]map[int]UnspentOutput {
+	return chain.unspentOutputsAt(chain.LastHash)
+}
 
-	spentTXOs := make(map[string][]int)
+// NOTE the set as it was right after the block `hash`
+func (chain *Blockchain) unspentOutputsAt(hash []byte) map[string]map[int]UnspentOutput {
+	UTXO := make(map[string]map[int]UnspentOutput)
+	spent := make(map[string]map[int]bool)
 
-	iter := chain.Iterator()
+	iter := chain.iteratorAt(hash)
 
 	for {
 		block := iter.Next()
 
+		// NOTE the walk goes from the tip back, so spends are known before the outputs
+		// NOTE they spend. Inputs of the whole block go first, a transaction may spend
+		// NOTE an output created earlier in the same block
 		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
+			if tx.IsCoinbase() {
+				continue
+			}
 
-		Outputs:
-			for outIdx, out := range tx.Output {
-				if spentTXOs[txID] != nil {
-					for _, spentOut := range spentTXOs[txID] {
-						if spentOut == outIdx {
-							continue Outputs
-						}
-					}
+			for _, in := range tx.Inputs {
+				inTxID := hex.EncodeToString(in.ID)
+				if spent[inTxID] == nil {
+					spent[inTxID] = make(map[int]bool)
 				}
+				spent[inTxID][in.Out] = true
+			}
+		}
 
-				// NOTE we put collected outs'
-				outs := UTXO[txID]
-				outs.Outs = append(outs.Outs, out)
-				UTXO[txID] = outs // NOTE put it back into map
+		for _, tx := range block.Transactions {
+			txID := hex.EncodeToString(tx.ID)
 
-			}
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Inputs {
-					// NOTE translate into string
-					inTxID := hex.EncodeToString(in.ID)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
+			for outIdx, out := range tx.Output {
+				if out.IsDataCarrier() || spent[txID][outIdx] {
+					continue
+				}
 
+				if UTXO[txID] == nil {
+					UTXO[txID] = make(map[int]UnspentOutput)
 				}
+				UTXO[txID][outIdx] = UnspentOutput{Output: out, Height: block.Height, Coinbase: tx.IsCoinbase()}
 			}
 		}
 
@@ -472,6 +571,7 @@ func (chain *Blockchain) FindUnspentTransactionsOutputs() map[string]TXOs {
 			break
 		}
 	}
+
 	return UTXO
 }
 
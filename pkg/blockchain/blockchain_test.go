@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/assert"
+)
+
+// NOTE databases of another format, or from before the format was kept, are refused
+func TestChainFormat(t *testing.T) {
+	addresses, _ := newTestWallets(t, 1)
+	chain, _ := newTestChain(t, "format", addresses[0])
+
+	assert.NoError(t, chain.Database.View(checkChainFormat))
+
+	for _, format := range [][]byte{{chainFormat - 1}, {chainFormat + 1}, nil} {
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			if format == nil {
+				return txn.Delete(chainFormatKey)
+			}
+			return txn.Set(chainFormatKey, format)
+		})
+		assert.NoError(t, err)
+
+		assert.ErrorIs(t, chain.Database.View(checkChainFormat), errChainFormat)
+	}
+}
+
+// NOTE a valid header with other transactions, or a broken proof of work, is not stored
+func TestAddBlockChecks(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+
+	chain, UTXO := newTestChain(t, "checks", alice)
+	mineTestBlock(chain, UTXO, alice, NewTransaction(wallets[0], bob, 5*Coin, UTXO))
+	block := chain.GetBlock(chain.LastHash)
+
+	other, _ := newTestChain(t, "checks_other", bob)
+
+	forged := block
+	forged.Transactions = append([]*Transaction{}, block.Transactions...)
+	forged.Transactions[0] = CoinbaseTx(bob, "")
+	assert.ErrorIs(t, other.AddBlock(&forged), errBlockMerkleRoot)
+
+	forged = block
+	forged.Nonce++
+	assert.ErrorIs(t, other.AddBlock(&forged), errHeaderPoW)
+
+	forged = block
+	forged.MerkleRoot = CreateBlock([]*Transaction{CoinbaseTx(bob, "")}, block.PrevHash, 1).MerkleRoot
+	assert.ErrorIs(t, other.AddBlock(&forged), errHeaderPoW)
+
+	assert.NoError(t, other.AddBlock(&block))
+	assert.Equal(t, block, other.GetBlock(block.Hash))
+}
@@ -0,0 +1,236 @@
+// NOTE compact block filters - a Golomb-coded set (pkg/gcs) per block of the pubkey hashes
+// NOTE its outputs pay and the outpoints it spends, keyed by the block hash. A light node
+// NOTE downloads filters instead of telling full nodes its addresses, and fetches only the
+// NOTE blocks whose filter matches its wallet. Filter headers chain the filters together:
+// NOTE 	header = sha(sha(filter) || header of the parent), zeros for the parent of genesis
+// NOTE 	cf- + block hash  -> filter
+// NOTE 	cfh- + block hash -> filter header
+// NOTE CoinsCache.Apply builds them as blocks are connected and Disconnect drops them.
+// NOTE Blocks connected before filters existed get theirs from `reindex`
+
+package blockchain
+
+import (
+	"blockchain/pkg/gcs"
+	"blockchain/pkg/sha"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	cfilterPrefix  = []byte("cf-")
+	cfheaderPrefix = []byte("cfh-")
+
+	errNoCFilter     = errors.New("no filter for the block")
+	errCFilterHeader = errors.New("filter doesn't match its header")
+	errBlockHeader   = errors.New("block doesn't match its header")
+)
+
+func cfilterKey(blockHash []byte) []byte {
+	return append(append([]byte{}, cfilterPrefix...), blockHash...)
+}
+
+func cfheaderKey(blockHash []byte) []byte {
+	return append(append([]byte{}, cfheaderPrefix...), blockHash...)
+}
+
+// NOTE txid || vout, what a filter holds for a spent output
+func FilterOutpoint(txID []byte, out int) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte{}, txID...), uint32(out))
+}
+
+func blockFilter(block *Block) []byte {
+	var items [][]byte
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				items = append(items, FilterOutpoint(in.ID, in.Out))
+			}
+		}
+
+		for _, out := range tx.Output {
+			items = append(items, out.owners()...)
+		}
+	}
+
+	return gcs.Build(block.Hash, items).Bytes()
+}
+
+func filterHeader(filter, prevHeader []byte) []byte {
+	filterHash := sha.ComputeHash(filter)
+	header := sha.ComputeHash(append(filterHash[:], prevHeader...))
+
+	return header[:]
+}
+
+// NOTE header of the parent filter, zeros below genesis and below a loaded snapshot
+func prevFilterHeader(txn *badger.Txn, prevHash []byte) ([]byte, error) {
+	if len(prevHash) == 0 {
+		return make([]byte, 32), nil
+	}
+
+	item, err := txn.Get(cfheaderKey(prevHash))
+	if err == badger.ErrKeyNotFound {
+		return make([]byte, 32), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return item.ValueCopy(nil)
+}
+
+// NOTE CFilter returns the filter of the block and its header
+func (chain *Blockchain) CFilter(blockHash []byte) ([]byte, []byte, error) {
+	var filter, header []byte
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(cfilterKey(blockHash))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("%w %x", errNoCFilter, blockHash)
+		}
+		if err != nil {
+			return err
+		}
+
+		if filter, err = item.ValueCopy(nil); err != nil {
+			return err
+		}
+
+		item, err = txn.Get(cfheaderKey(blockHash))
+		if err != nil {
+			return err
+		}
+
+		header, err = item.ValueCopy(nil)
+		return err
+	})
+
+	return filter, header, err
+}
+
+// NOTE CFilterHeight is the height of the highest main chain block with a filter, -1 for none
+func (chain *Blockchain) CFilterHeight() int {
+	for height := chain.BestHeight(); height >= 0; height-- {
+		block, err := chain.BlockAtHeight(height)
+		if err != nil {
+			return -1
+		}
+
+		if _, _, err := chain.CFilter(block.Hash); err == nil {
+			return height
+		}
+	}
+
+	return -1
+}
+
+// NOTE AddCFilter keeps the filter of a known header, light nodes only. The filter must
+// NOTE give the header sent with it on top of the filter header of the parent
+func (chain *Blockchain) AddCFilter(blockHash, filter, header []byte) error {
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(blockHash)
+		if err != nil {
+			return fmt.Errorf("header %x: %w", blockHash, err)
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		prev, err := prevFilterHeader(txn, DeserializeBlock(v).PrevHash)
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(filterHeader(filter, prev), header) {
+			return fmt.Errorf("%w: block %x", errCFilterHeader, blockHash)
+		}
+
+		if err := txn.Set(cfilterKey(blockHash), filter); err != nil {
+			return err
+		}
+
+		return txn.Set(cfheaderKey(blockHash), header)
+	})
+}
+
+// NOTE WalletFilterItems is what a light wallet looks for in filters: its pubkey hashes,
+// NOTE for payments to it, and its proven unspent outputs, for payments from it
+func (chain *Blockchain) WalletFilterItems(pubKeyHashes [][]byte) [][]byte {
+	items := append([][]byte{}, pubKeyHashes...)
+
+	for _, pubKeyHash := range pubKeyHashes {
+		for _, out := range chain.ProvenOutputs(pubKeyHash) {
+			items = append(items, FilterOutpoint(out.TxID, out.Out))
+		}
+	}
+
+	return items
+}
+
+// NOTE MatchCFilter tells whether the block may touch any of items
+func MatchCFilter(blockHash, filter []byte, items [][]byte) (bool, error) {
+	set, err := gcs.FromBytes(filter)
+	if err != nil {
+		return false, err
+	}
+
+	return set.MatchAny(blockHash, items), nil
+}
+
+// NOTE AddBlockProofs checks a block fetched for a matching filter against its header
+// NOTE and keeps proofs of its transactions touching pubKeyHashes, light nodes only.
+// NOTE Returns how many
+func (chain *Blockchain) AddBlockProofs(block *Block, pubKeyHashes [][]byte) (int, error) {
+	header, err := chain.BlockAtHeight(block.Height)
+	if err != nil {
+		return 0, err
+	}
+
+	if !bytes.Equal(header.Hash, block.Hash) || !bytes.Equal(block.HashTransactions(), header.MerkleRoot) {
+		return 0, fmt.Errorf("%w: %x", errBlockHeader, block.Hash)
+	}
+
+	watched := make(map[string]bool)
+	for _, item := range chain.WalletFilterItems(pubKeyHashes) {
+		watched[string(item)] = true
+	}
+
+	added := 0
+
+	for _, tx := range block.Transactions {
+		relevant := false
+
+		for _, address := range tx.addresses() {
+			relevant = relevant || watched[string(address)]
+		}
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				relevant = relevant || watched[string(FilterOutpoint(in.ID, in.Out))]
+			}
+		}
+
+		if !relevant {
+			continue
+		}
+
+		proof, _, err := blockTxProof(block, tx.ID)
+		if err != nil {
+			return added, err
+		}
+		if err := chain.AddTxProof(proof); err != nil {
+			return added, err
+		}
+
+		added++
+	}
+
+	return added, nil
+}
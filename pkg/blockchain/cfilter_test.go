@@ -0,0 +1,102 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/gcs"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCFilters(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 3)
+	alice, bob, carol := addresses[0], addresses[1], addresses[2]
+	aliceW, bobW := wallets[0], wallets[1]
+	watched := [][]byte{wallet.AddressPubKeyHash(bob)}
+
+	chain, UTXO := newTestChain(t, "cfilter_full", alice)
+	mineTestBlock(chain, UTXO, alice, NewTransaction(aliceW, bob, 7*Coin, UTXO))
+	mineTestBlock(chain, UTXO, alice)
+	mineTestBlock(chain, UTXO, alice, NewTransaction(bobW, carol, 2*Coin, UTXO))
+	assert.Equal(t, 3, chain.CFilterHeight())
+
+	light, err := OpenHeaderChain("cfilter_light")
+	assert.NoError(t, err)
+	t.Cleanup(func() { light.Database.Close() })
+	syncHeaders(t, chain, light)
+
+	var matched []int
+
+	for height := 0; height <= 3; height++ {
+		block, err := chain.BlockAtHeight(height)
+		assert.NoError(t, err)
+
+		filter, header, err := chain.CFilter(block.Hash)
+		assert.NoError(t, err)
+
+		// NOTE the filter chain doesn't take a filter of another block
+		if height == 2 {
+			other, _, _ := chain.CFilter(block.PrevHash)
+			assert.ErrorIs(t, light.AddCFilter(block.Hash, other, header), errCFilterHeader)
+		}
+		assert.NoError(t, light.AddCFilter(block.Hash, filter, header))
+
+		// NOTE spends are found through the outputs proven before
+		match, err := MatchCFilter(block.Hash, filter, light.WalletFilterItems(watched))
+		assert.NoError(t, err)
+		if !match {
+			continue
+		}
+		matched = append(matched, height)
+
+		added, err := light.AddBlockProofs(block, watched)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, added)
+	}
+
+	assert.Equal(t, []int{1, 3}, matched)
+	assert.Equal(t, 3, light.CFilterHeight())
+
+	balances, err := light.ProvenBalances(watched[0])
+	assert.NoError(t, err)
+	assert.Equal(t, balance(UTXO, bob), balances[""])
+
+	// NOTE a block which is not the one of the header is refused
+	block, err := chain.BlockAtHeight(3)
+	assert.NoError(t, err)
+	forged := *block
+	forged.Transactions = block.Transactions[:1]
+	_, err = light.AddBlockProofs(&forged, watched)
+	assert.ErrorIs(t, err, errBlockHeader)
+
+	// NOTE filters follow the main chain
+	assert.NoError(t, UTXO.Disconnect(block))
+	_, _, err = chain.CFilter(block.Hash)
+	assert.ErrorIs(t, err, errNoCFilter)
+}
+
+func TestGCSFalsePositives(t *testing.T) {
+	var items [][]byte
+	for i := 0; i < 1000; i++ {
+		items = append(items, []byte(fmt.Sprintf("item%d", i)))
+	}
+
+	key := []byte("block")
+	filter, err := gcs.FromBytes(gcs.Build(key, items).Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, filter.N())
+
+	for _, item := range items {
+		assert.True(t, filter.Match(key, item))
+	}
+
+	// NOTE about one in M
+	positives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.Match(key, []byte(fmt.Sprintf("other%d", i))) {
+			positives++
+		}
+	}
+	assert.Less(t, positives, 5)
+}
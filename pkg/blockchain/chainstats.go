@@ -0,0 +1,204 @@
+// NOTE supply and distribution of the native coin, computed from the UTXO set at its
+// NOTE last block and cached in the database next to it. The cache holds the stats of
+// NOTE one tip, a new block makes the next ChainStats call compute them again.
+// NOTE Balances count plain outputs only, coins under hash time locks and channels are
+// NOTE part of the total but of no address
+
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"bytes"
+	"encoding/gob"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+)
+
+// NOTE longest top list kept in the cache
+const MaxStatsTop = 100
+
+var (
+	chainStatsKey = []byte("chainstats")
+
+	// NOTE lower bounds of the balance buckets, the last one has no upper bound
+	balanceBuckets = []Amount{0, Coin, 10 * Coin, 100 * Coin, 1000 * Coin, 10000 * Coin}
+)
+
+type (
+	AddressBalance struct {
+		Address string
+		Balance Amount
+	}
+
+	// NOTE addresses with Min <= balance < Max, Max 0 means no upper bound
+	BalanceBucket struct {
+		Min       Amount
+		Max       Amount
+		Addresses int
+		Total     Amount
+	}
+
+	ChainStats struct {
+		Height    int
+		BestBlock []byte
+		// NOTE every block pays Subsidy, what is missing from Total went to fees and data carriers
+		Issued    Amount
+		Outputs   int
+		Total     Amount
+		Addresses int
+		// NOTE richest addresses first
+		Top     []AddressBalance
+		Buckets []BalanceBucket
+	}
+)
+
+// NOTE ChainStats returns the stats at the last block of the set with the `top` richest
+// NOTE addresses, at most MaxStatsTop
+func (u UnspentTransactionSET) ChainStats(top int) (ChainStats, error) {
+	if u.Cache != nil {
+		if err := u.Cache.Flush(); err != nil {
+			return ChainStats{}, err
+		}
+	}
+
+	var (
+		stats    ChainStats
+		computed bool
+	)
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		commitment, err := loadSetCommitment(txn)
+		if err != nil {
+			return err
+		}
+
+		cached, found, err := loadChainStats(txn)
+		if err != nil {
+			return err
+		}
+
+		if found && cached.Height == commitment.state.Height && bytes.Equal(cached.BestBlock, commitment.state.BestBlock) {
+			stats = cached
+			return nil
+		}
+
+		stats, err = computeChainStats(txn, commitment.state)
+		computed = err == nil
+		return err
+	})
+	if err != nil {
+		return ChainStats{}, err
+	}
+
+	// NOTE stats of a tip the set has already left just miss on the next call
+	if computed {
+		err = u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+			return saveChainStats(txn, stats)
+		})
+		if err != nil {
+			return ChainStats{}, err
+		}
+	}
+
+	if top < len(stats.Top) {
+		stats.Top = stats.Top[:max(top, 0)]
+	}
+
+	return stats, nil
+}
+
+func computeChainStats(txn *badger.Txn, state utxoState) (ChainStats, error) {
+	stats := ChainStats{
+		Height:    state.Height,
+		BestBlock: state.BestBlock,
+		Issued:    Amount(state.Height+1) * Subsidy,
+	}
+
+	balances := make(map[string]Amount)
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+		v, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return stats, err
+		}
+
+		out := DeserializeUnspentOutput(v).Output
+		stats.Outputs++
+
+		if out.Asset != nil {
+			continue
+		}
+
+		stats.Total += out.Value
+		if len(out.PubkeyHash) > 0 {
+			balances[string(out.PubkeyHash)] += out.Value
+		}
+	}
+
+	for i, lower := range balanceBuckets {
+		bucket := BalanceBucket{Min: lower}
+		if i+1 < len(balanceBuckets) {
+			bucket.Max = balanceBuckets[i+1]
+		}
+		stats.Buckets = append(stats.Buckets, bucket)
+	}
+
+	ranked := make([]AddressBalance, 0, len(balances))
+
+	for pubKeyHash, balance := range balances {
+		if balance == 0 {
+			continue
+		}
+
+		ranked = append(ranked, AddressBalance{Address: wallet.PubKeyHashAddress([]byte(pubKeyHash)), Balance: balance})
+
+		bucket := &stats.Buckets[sort.Search(len(balanceBuckets), func(i int) bool { return balanceBuckets[i] > balance })-1]
+		bucket.Addresses++
+		bucket.Total += balance
+	}
+
+	stats.Addresses = len(ranked)
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Balance != ranked[j].Balance {
+			return ranked[i].Balance > ranked[j].Balance
+		}
+		return ranked[i].Address < ranked[j].Address
+	})
+
+	stats.Top = ranked[:min(len(ranked), MaxStatsTop)]
+
+	return stats, nil
+}
+
+func loadChainStats(txn *badger.Txn) (ChainStats, bool, error) {
+	var stats ChainStats
+
+	item, err := txn.Get(chainStatsKey)
+	if err == badger.ErrKeyNotFound {
+		return stats, false, nil
+	}
+	if err != nil {
+		return stats, false, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		return gob.NewDecoder(bytes.NewReader(val)).Decode(&stats)
+	})
+
+	return stats, err == nil, err
+}
+
+func saveChainStats(txn kvWriter, stats ChainStats) error {
+	var buffer bytes.Buffer
+
+	if err := gob.NewEncoder(&buffer).Encode(stats); err != nil {
+		return err
+	}
+
+	return txn.Set(chainStatsKey, buffer.Bytes())
+}
@@ -0,0 +1,41 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainStats(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 3)
+	alice, bob, carol := addresses[0], addresses[1], addresses[2]
+	aliceW := wallets[0]
+
+	chain, UTXO := newTestChain(t, "chainstats", alice)
+	mineTestBlock(chain, UTXO, carol, NewTransaction(aliceW, bob, Coin/2, UTXO))
+
+	stats, err := UTXO.ChainStats(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Height)
+	assert.Equal(t, 2*Subsidy, stats.Issued)
+	assert.Equal(t, 2*Subsidy, stats.Total)
+	assert.Equal(t, 3, stats.Outputs)
+	assert.Equal(t, 3, stats.Addresses)
+
+	// NOTE carol has the whole subsidy, alice what she didn't send to bob
+	assert.Equal(t, []AddressBalance{{carol, Subsidy}, {alice, Subsidy - Coin/2}}, stats.Top)
+
+	assert.Equal(t, BalanceBucket{Min: 0, Max: Coin, Addresses: 1, Total: Coin / 2}, stats.Buckets[0])
+	assert.Equal(t, BalanceBucket{Min: 10 * Coin, Max: 100 * Coin, Addresses: 2, Total: 2*Subsidy - Coin/2}, stats.Buckets[2])
+
+	// NOTE cached until the next block
+	cached, err := UTXO.ChainStats(MaxStatsTop)
+	assert.NoError(t, err)
+	assert.Len(t, cached.Top, 3)
+
+	mineTestBlock(chain, UTXO, carol)
+	stats, err = UTXO.ChainStats(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Height)
+	assert.Equal(t, []AddressBalance{{carol, 2 * Subsidy}}, stats.Top)
+}
@@ -0,0 +1,289 @@
+// NOTE unidirectional payment channel - many payments from payer to payee, two transactions on chain:
+// NOTE 	open        - payer locks the capacity in a 2-of-2 output of both public keys
+// NOTE 	pay         - payer signs a new commitment (spend of the funding output) which gives
+// NOTE 	              the payee a bigger share, and hands it over off-chain
+// NOTE 	close       - payee adds the second signature to the latest commitment and broadcasts it
+// NOTE 	force close - if the payee disappears, the payer takes everything back after Timeout
+// NOTE Money only flows one way, so the payee always closes with the latest commitment
+// NOTE and older ones are worthless to the payer
+
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/utils"
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	payerSlot = iota
+	payeeSlot
+	channelSlots
+)
+
+var (
+	errChannelLock       = errors.New("malformed channel lock")
+	errChannelFunding    = errors.New("output is not a channel funding output")
+	errChannelCapacity   = errors.New("payment exceeds the channel capacity")
+	errChannelCommitment = errors.New("commitment does not match the channel")
+	errChannelEmpty      = errors.New("channel has no commitment to close with")
+)
+
+type (
+	// NOTE 2-of-2 lock of both public keys, or the payer alone from Timeout
+	ChannelLock struct {
+		Payer   []byte
+		Payee   []byte
+		Timeout int
+	}
+
+	// NOTE channel state, both sides keep their own copy
+	PaymentChannel struct {
+		Funding Transaction
+		Out     int
+		// NOTE payee's share in the latest commitment
+		Paid Amount
+		// NOTE latest commitment, signed by the payer only
+		Commitment *Transaction
+	}
+)
+
+func NewChannelTXO(value Amount, payer, payee []byte, timeout int) *TXO {
+	return &TXO{
+		Value:   value,
+		Channel: &ChannelLock{Payer: payer, Payee: payee, Timeout: timeout},
+	}
+}
+
+func (l *ChannelLock) check() error {
+	if len(l.Payer) == 0 || len(l.Payee) == 0 || bytes.Equal(l.Payer, l.Payee) || l.Timeout <= 0 {
+		return errChannelLock
+	}
+
+	return nil
+}
+
+func (l *ChannelLock) serialize() []byte {
+	return bytes.Join([][]byte{l.Payer, l.Payee, utils.ToHex(int64(l.Timeout))}, []byte{})
+}
+
+// NOTE witness slot of the key, -1 if the key is not a party of the channel
+func (l *ChannelLock) slot(pubKey []byte) int {
+	switch {
+	case bytes.Equal(pubKey, l.Payer):
+		return payerSlot
+	case bytes.Equal(pubKey, l.Payee):
+		return payeeSlot
+	}
+
+	return -1
+}
+
+// NOTE OpenChannel funds a channel of `capacity` from the payer's wallet to the `payee` public key.
+// NOTE Funding transaction is c.Funding, it has to be mined before the channel is used
+func OpenChannel(payer *wallet.Wallet, payee []byte, capacity Amount, timeout int, UTXO *UnspentTransactionSET) *PaymentChannel {
+	funding := NewChannelTXO(capacity, payer.PublicKey, payee, timeout)
+	if err := funding.Channel.check(); err != nil {
+		utils.DisplayErr(err)
+	}
+
+	tx := buildTransaction(payer, []TXO{*funding}, nil, nil, UTXO)
+
+	// NOTE funding output goes first, change follows
+	c, err := NewPaymentChannel(*tx, 0)
+	utils.DisplayErr(err)
+
+	return c
+}
+
+// NOTE NewPaymentChannel is the payee side of OpenChannel: it tracks the channel
+// NOTE of funding output `out` of a funding transaction seen on chain
+func NewPaymentChannel(funding Transaction, out int) (*PaymentChannel, error) {
+	if out < 0 || out >= len(funding.Output) || funding.Output[out].Channel == nil {
+		return nil, errChannelFunding
+	}
+
+	return &PaymentChannel{Funding: funding, Out: out}, nil
+}
+
+func (c *PaymentChannel) lock() *ChannelLock {
+	return c.Funding.Output[c.Out].Channel
+}
+
+func (c *PaymentChannel) Capacity() Amount {
+	return c.Funding.Output[c.Out].Value
+}
+
+func (c *PaymentChannel) prevTransactions() map[string]Transaction {
+	return map[string]Transaction{hex.EncodeToString(c.Funding.ID): c.Funding}
+}
+
+// NOTE unsigned commitment which pays `paid` to the payee and the rest back to the payer
+func (c *PaymentChannel) commitment(paid Amount) (*Transaction, error) {
+	rest, err := c.Capacity().Sub(paid)
+	if err != nil {
+		return nil, errChannelCapacity
+	}
+
+	lock := c.lock()
+	tx := Transaction{
+		Inputs: []TXI{{ID: c.Funding.ID, Out: c.Out, Witness: make([][]byte, channelSlots)}},
+	}
+
+	if paid > 0 {
+		tx.Output = append(tx.Output, TXO{Value: paid, PubkeyHash: wallet.PublicKey(lock.Payee)})
+	}
+	if rest > 0 {
+		tx.Output = append(tx.Output, TXO{Value: rest, PubkeyHash: wallet.PublicKey(lock.Payer)})
+	}
+	tx.ID = tx.Hash()
+
+	return &tx, nil
+}
+
+// NOTE Pay moves `amount` more to the payee. Returned commitment is signed by the payer
+// NOTE and goes to the payee off-chain, nothing is broadcast
+func (c *PaymentChannel) Pay(payer *wallet.Wallet, amount Amount) (*Transaction, error) {
+	if c.lock().slot(payer.PublicKey) != payerSlot {
+		return nil, fmt.Errorf("%w: not the payer", errChannelCommitment)
+	}
+
+	paid, err := c.Paid.Add(amount)
+	if err != nil || amount <= 0 || paid > c.Capacity() {
+		return nil, errChannelCapacity
+	}
+
+	tx, err := c.commitment(paid)
+	if err != nil {
+		return nil, err
+	}
+	tx.Sign(payer.PrivateKey, c.prevTransactions())
+
+	c.Paid, c.Commitment = paid, tx
+
+	return tx, nil
+}
+
+// NOTE Accept is the payee side of Pay: the commitment must match the channel,
+// NOTE carry a valid payer signature and pay more than the previous one
+func (c *PaymentChannel) Accept(commitment *Transaction) error {
+	if len(commitment.Output) == 0 || len(commitment.Inputs) != 1 {
+		return errChannelCommitment
+	}
+
+	lock := c.lock()
+	paid := commitment.Output[0].Value
+	if !commitment.Output[0].IsLockedWithKey(wallet.PublicKey(lock.Payee)) {
+		paid = 0
+	}
+	if paid <= c.Paid {
+		return fmt.Errorf("%w: pays %s, already have %s", errChannelCommitment, paid, c.Paid)
+	}
+
+	expected, err := c.commitment(paid)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(expected.ID, commitment.Hash()) {
+		return errChannelCommitment
+	}
+
+	in := commitment.Inputs[0]
+	prevOut := c.Funding.Output[c.Out]
+	if len(in.Witness) != channelSlots ||
+		!commitment.checkSignature(0, prevOut, in.Witness[payerSlot], lock.Payer, verifySignature) {
+		return fmt.Errorf("%w: bad payer signature", errChannelCommitment)
+	}
+
+	c.Paid, c.Commitment = paid, commitment
+
+	return nil
+}
+
+// NOTE Close completes the latest commitment with the payee signature, ready to be mined
+func (c *PaymentChannel) Close(payee *wallet.Wallet) (*Transaction, error) {
+	if c.Commitment == nil {
+		return nil, errChannelEmpty
+	}
+
+	tx := *c.Commitment
+	tx.Inputs = append([]TXI{}, c.Commitment.Inputs...)
+	tx.Inputs[0].Witness = append([][]byte{}, c.Commitment.Inputs[0].Witness...)
+
+	tx.Sign(payee.PrivateKey, c.prevTransactions())
+	tx.ID = tx.Hash()
+
+	if !tx.VerifyInput(0, c.Funding.Output[c.Out]) {
+		return nil, fmt.Errorf("%w: not signed by both parties", errChannelCommitment)
+	}
+
+	return &tx, nil
+}
+
+// NOTE ForceClose refunds the whole capacity to the payer, it can be mined from Timeout on
+func (c *PaymentChannel) ForceClose(payer *wallet.Wallet) (*Transaction, error) {
+	lock := c.lock()
+	tx := Transaction{
+		Inputs:   []TXI{{ID: c.Funding.ID, Out: c.Out}},
+		Output:   []TXO{{Value: c.Capacity(), PubkeyHash: wallet.PublicKey(lock.Payer)}},
+		LockTime: lock.Timeout,
+	}
+
+	if err := tx.SignInput(payer.PrivateKey, 0, c.Funding.Output[c.Out], SigHashAll); err != nil {
+		return nil, err
+	}
+	tx.ID = tx.Hash()
+
+	if !tx.VerifyInput(0, c.Funding.Output[c.Out]) {
+		return nil, fmt.Errorf("%w: not the payer", errChannelCommitment)
+	}
+
+	return &tx, nil
+}
+
+// NOTE channel inputs collect one signature per party in the witness, see Sign
+func (t *Transaction) signChannelInput(private ecdsa.PrivateKey, inIdx int, prevOut TXO, hashType SigHashType) error {
+	// NOTE the lock keeps the keys as the wallets stored them, legacy ones unpadded
+	pubKey, ok := signingKey(private, prevOut)
+	if !ok {
+		return fmt.Errorf("%w: input %d, not a party of the channel", errNotSigner, inIdx)
+	}
+	slot := prevOut.Channel.slot(pubKey)
+
+	signature, err := t.SignatureFor(private, inIdx, prevOut, hashType)
+	if err != nil {
+		return err
+	}
+
+	in := &t.Inputs[inIdx]
+	if len(in.Witness) != channelSlots {
+		in.Witness = make([][]byte, channelSlots)
+	}
+	in.Witness[slot] = signature
+
+	return nil
+}
+
+// NOTE two witness signatures - cooperative close, no witness - payer's refund after Timeout
+func (t *Transaction) verifyChannel(inIdx int, prevOut TXO, verify sigVerifier) bool {
+	in := t.Inputs[inIdx]
+	lock := prevOut.Channel
+
+	switch len(in.Witness) {
+	case channelSlots:
+		return t.checkSignature(inIdx, prevOut, in.Witness[payerSlot], lock.Payer, verify) &&
+			t.checkSignature(inIdx, prevOut, in.Witness[payeeSlot], lock.Payee, verify)
+	case 0:
+		if t.LockTime < lock.Timeout || !bytes.Equal(in.PubKey, lock.Payer) {
+			return false
+		}
+
+		return t.checkSignature(inIdx, prevOut, in.Signature, in.PubKey, verify)
+	}
+
+	return false
+}
@@ -0,0 +1,80 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentChannel(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	payer, payee := addresses[0], addresses[1]
+	payerW, payeeW := wallets[0], wallets[1]
+
+	chain, UTXO := newTestChain(t, "channel", payer)
+
+	sender := OpenChannel(payerW, payeeW.PublicKey, 10*Coin, 100, UTXO)
+	mineTestBlock(chain, UTXO, payer, &sender.Funding)
+
+	receiver, err := NewPaymentChannel(sender.Funding, sender.Out)
+	assert.NoError(t, err)
+
+	// NOTE payee can't close before the first payment
+	_, err = receiver.Close(payeeW)
+	assert.ErrorIs(t, err, errChannelEmpty)
+
+	var stale *Transaction
+	for i := 0; i < 5; i++ {
+		commitment, err := sender.Pay(payerW, Coin)
+		assert.NoError(t, err)
+		assert.NoError(t, receiver.Accept(commitment))
+
+		if i == 0 {
+			stale = commitment
+		}
+	}
+
+	// NOTE older commitments and overdrafts are refused
+	assert.Error(t, receiver.Accept(stale))
+	_, err = sender.Pay(payerW, 6*Coin)
+	assert.ErrorIs(t, err, errChannelCapacity)
+
+	// NOTE payer's signature alone doesn't spend the funding output
+	assert.False(t, chain.VerifyTransaction(receiver.Commitment))
+
+	closing, err := receiver.Close(payeeW)
+	assert.NoError(t, err)
+	mineTestBlock(chain, UTXO, payer, closing)
+
+	assert.Equal(t, 5*Coin, balance(UTXO, payee))
+	assert.Equal(t, 3*Subsidy-5*Coin, balance(UTXO, payer))
+}
+
+func TestPaymentChannelForceClose(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	payer, payee := addresses[0], addresses[1]
+	payerW, payeeW := wallets[0], wallets[1]
+
+	chain, UTXO := newTestChain(t, "forceclose", payer)
+
+	channel := OpenChannel(payerW, payeeW.PublicKey, 10*Coin, 3, UTXO)
+	mineTestBlock(chain, UTXO, payer, &channel.Funding)
+
+	_, err := channel.Pay(payerW, 4*Coin)
+	assert.NoError(t, err)
+
+	// NOTE payee can't take the refund path
+	_, err = channel.ForceClose(payeeW)
+	assert.Error(t, err)
+
+	refund, err := channel.ForceClose(payerW)
+	assert.NoError(t, err)
+	assert.False(t, chain.VerifyTransaction(refund))
+
+	mineTestBlock(chain, UTXO, payer)
+	assert.True(t, chain.VerifyTransaction(refund))
+	mineTestBlock(chain, UTXO, payer, refund)
+
+	assert.Equal(t, 4*Subsidy, balance(UTXO, payer))
+	assert.Equal(t, Amount(0), balance(UTXO, payee))
+}
@@ -0,0 +1,431 @@
+// NOTE write-back cache of the UTXO set. Blocks are applied in memory: outputs they
+// NOTE create and spend live in a map, the rest of their writes (undo data, indexes)
+// NOTE go to a WriteBatch. Flush writes the dirty outputs and the set commitment through
+// NOTE the same batch, automatically once the cache is over its memory budget.
+// NOTE An output created and spent between two flushes never touches the database.
+// NOTE utxoFlushKey is set while the database is behind the cache (flushBehind) and while
+// NOTE outputs are being written (flushWriting). A node which dies before the flush is
+// NOTE done rebuilds its set on the next start (see Migrate), a reindex goes on from its
+// NOTE last flush unless the flush itself was cut (see reindex.go)
+
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+// NOTE memory budget of caches made by Update and Reindex, in bytes
+var CoinsCacheBudget = 64 << 20
+
+const (
+	flushBehind  byte = 1
+	flushWriting byte = 2
+)
+
+var (
+	utxoFlushKey = []byte("utxoflush")
+
+	errSpent = errors.New("output is already spent")
+)
+
+// NOTE both *badger.Txn and *badger.WriteBatch
+type kvWriter interface {
+	Set(key, value []byte) error
+	Delete(key []byte) error
+}
+
+type (
+	cachedOutput struct {
+		unspent UnspentOutput
+		value   []byte
+		spent   bool
+		// NOTE not in the database, so a spend just drops it
+		fresh bool
+		// NOTE differs from the database
+		dirty bool
+	}
+
+	CoinsCache struct {
+		db *badger.DB
+		// NOTE flush once the estimated memory use goes over it
+		Budget int
+
+		outputs    map[string]*cachedOutput
+		usage      int
+		batch      *badger.WriteBatch
+		commitment *setCommitment
+		height     int
+		bestBlock  []byte
+		indexed    bool
+		dirty      bool
+		// NOTE filter header of bestBlock, the batch may not be written yet
+		filterHeader []byte
+	}
+)
+
+// NOTE rough bytes of a map entry besides its key and value
+const cachedOutputOverhead = 96
+
+// NOTE NewCoinsCache starts on top of the set in the database, budget <= 0 means CoinsCacheBudget
+func (u *UnspentTransactionSET) NewCoinsCache(budget int) (*CoinsCache, error) {
+	if budget <= 0 {
+		budget = CoinsCacheBudget
+	}
+
+	cache := &CoinsCache{
+		db:      u.Blockchain.Database,
+		Budget:  budget,
+		outputs: make(map[string]*cachedOutput),
+		indexed: u.AddressIndexEnabled(),
+	}
+
+	err := cache.db.View(func(txn *badger.Txn) error {
+		commitment, err := loadSetCommitment(txn)
+		if err != nil {
+			return err
+		}
+
+		cache.commitment = commitment
+		cache.height, cache.bestBlock = commitment.state.Height, commitment.state.BestBlock
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+func (c *CoinsCache) put(key string, entry *cachedOutput) {
+	if old, ok := c.outputs[key]; ok {
+		c.usage -= len(key) + len(old.value) + cachedOutputOverhead
+	}
+
+	c.outputs[key] = entry
+	c.usage += len(key) + len(entry.value) + cachedOutputOverhead
+}
+
+// NOTE unspent output from the cache, or the database when it isn't there yet
+func (c *CoinsCache) fetch(key []byte) (*cachedOutput, error) {
+	if entry, ok := c.outputs[string(key)]; ok {
+		if entry.spent {
+			return nil, errSpent
+		}
+		return entry, nil
+	}
+
+	var entry *cachedOutput
+
+	err := c.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		entry = &cachedOutput{unspent: DeserializeUnspentOutput(v), value: v}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.put(string(key), entry)
+
+	return entry, nil
+}
+
+// NOTE unspent output as the cache sees it, from its own database when it isn't cached.
+// NOTE Blocks are verified against it before they are applied
+func (c *CoinsCache) unspent(txID []byte, out int) (UnspentOutput, bool) {
+	entry, err := c.fetch(outpointKey(txID, out))
+	if err != nil {
+		return UnspentOutput{}, false
+	}
+
+	return entry.unspent, true
+}
+
+// NOTE the cached state of an output: unspent output, whether it is unspent, whether the cache knows it
+func (c *CoinsCache) lookup(txID []byte, out int) (UnspentOutput, bool, bool) {
+	entry, ok := c.outputs[string(outpointKey(txID, out))]
+	if !ok {
+		return UnspentOutput{}, false, false
+	}
+
+	return entry.unspent, !entry.spent, true
+}
+
+// NOTE flushBehind, flushWriting or 0 when the set in the database is complete
+func flushState(txn *badger.Txn) (byte, error) {
+	item, err := txn.Get(utxoFlushKey)
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := item.ValueCopy(nil)
+	if err != nil || len(v) != 1 {
+		return flushWriting, err
+	}
+
+	return v[0], nil
+}
+
+func (c *CoinsCache) setFlushState(state byte) error {
+	return c.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(utxoFlushKey, []byte{state})
+	})
+}
+
+// NOTE the database must not be trusted from the first cached block until the flush
+func (c *CoinsCache) markDirty() error {
+	if c.dirty {
+		return nil
+	}
+
+	if err := c.setFlushState(flushBehind); err != nil {
+		return err
+	}
+
+	c.batch = c.db.NewWriteBatch()
+	c.dirty = true
+
+	return nil
+}
+
+// NOTE Apply does what Update does, in memory. The block must extend the last one applied.
+// NOTE A block spending a missing or spent output changes nothing, the flush marker included
+func (c *CoinsCache) Apply(block *Block) error {
+	if err := c.checkSpends(block); err != nil {
+		return err
+	}
+
+	if err := c.markDirty(); err != nil {
+		return err
+	}
+
+	var undo []SpentOutput
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				// NOTE every output has its own key, so spending one
+				// NOTE leaves the positions of the others untouched
+				key := outpointKey(in.ID, in.Out)
+
+				entry, err := c.fetch(key)
+				if err != nil {
+					return fmt.Errorf("transaction %x spends %x:%d: %w", tx.ID, in.ID, in.Out, err)
+				}
+
+				// NOTE spent outputs are kept as undo data of the block, see Disconnect
+				spent := SpentOutput{TxID: in.ID, Out: in.Out, Unspent: entry.unspent}
+				undo = append(undo, spent)
+
+				if entry.fresh {
+					c.usage -= len(key) + len(entry.value) + cachedOutputOverhead
+					delete(c.outputs, string(key))
+				} else {
+					entry.spent, entry.dirty = true, true
+				}
+
+				if err := c.commitment.remove(spent.TxID, spent.Out, spent.Unspent); err != nil {
+					return err
+				}
+
+				if c.indexed {
+					if err := unindexUnspent(c.batch, spent.TxID, spent.Out, spent.Unspent); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		for outIdx, out := range tx.Output {
+			// NOTE data carriers can't be spent, so they never enter the set
+			if out.IsDataCarrier() {
+				continue
+			}
+
+			unspent := UnspentOutput{Output: out, Height: block.Height, Coinbase: tx.IsCoinbase()}
+			c.put(string(outpointKey(tx.ID, outIdx)), &cachedOutput{unspent: unspent, value: unspent.Serialize(), fresh: true, dirty: true})
+
+			if err := c.commitment.add(tx.ID, outIdx, unspent); err != nil {
+				return err
+			}
+
+			if c.indexed {
+				if err := indexUnspent(c.batch, tx.ID, outIdx, unspent); err != nil {
+					return err
+				}
+			}
+		}
+
+		if c.indexed {
+			if err := indexTransaction(c.batch, tx, block.Height); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := c.batch.Set(undoKey(block.Hash), serializeUndo(undo)); err != nil {
+		return err
+	}
+
+	// NOTE payloads of data carriers go to their own index
+	if err := indexDataCarriers(c.batch, block); err != nil {
+		return err
+	}
+
+	if err := c.addFilter(block); err != nil {
+		return err
+	}
+
+	c.height, c.bestBlock = block.Height, block.Hash
+
+	if c.usage > c.Budget {
+		return c.Flush()
+	}
+
+	return nil
+}
+
+// NOTE every input spends an output of the set or one created earlier in the block, once
+func (c *CoinsCache) checkSpends(block *Block) error {
+	created := make(map[string]bool)
+	spent := make(map[string]bool)
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				key := outpointKey(in.ID, in.Out)
+
+				if spent[string(key)] {
+					return fmt.Errorf("transaction %x spends %x:%d: %w", tx.ID, in.ID, in.Out, errSpent)
+				}
+				spent[string(key)] = true
+
+				if created[string(key)] {
+					continue
+				}
+				_, err := c.fetch(key)
+				if err == badger.ErrKeyNotFound {
+					err = errSpent
+				}
+				if err != nil {
+					return fmt.Errorf("transaction %x spends %x:%d: %w", tx.ID, in.ID, in.Out, err)
+				}
+			}
+		}
+
+		for outIdx, out := range tx.Output {
+			if !out.IsDataCarrier() {
+				created[string(outpointKey(tx.ID, outIdx))] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// NOTE compact filter of the block and its header, see cfilter.go
+func (c *CoinsCache) addFilter(block *Block) error {
+	prev := c.filterHeader
+
+	if prev == nil || !bytes.Equal(block.PrevHash, c.bestBlock) {
+		err := c.db.View(func(txn *badger.Txn) error {
+			var err error
+			prev, err = prevFilterHeader(txn, block.PrevHash)
+
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	filter := blockFilter(block)
+	header := filterHeader(filter, prev)
+
+	if err := c.batch.Set(cfilterKey(block.Hash), filter); err != nil {
+		return err
+	}
+	if err := c.batch.Set(cfheaderKey(block.Hash), header); err != nil {
+		return err
+	}
+
+	c.filterHeader = header
+	return nil
+}
+
+// NOTE Flush writes everything applied so far, the cache is empty afterwards
+func (c *CoinsCache) Flush() error {
+	if !c.dirty {
+		return nil
+	}
+
+	// NOTE only now the outputs in the database stop matching the last commitment
+	if err := c.setFlushState(flushWriting); err != nil {
+		return err
+	}
+
+	for key, entry := range c.outputs {
+		if !entry.dirty {
+			continue
+		}
+
+		var err error
+		if entry.spent {
+			err = c.batch.Delete([]byte(key))
+		} else {
+			err = c.batch.Set([]byte(key), entry.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := c.commitment.save(c.batch, c.height, c.bestBlock); err != nil {
+		return err
+	}
+
+	if err := c.batch.Flush(); err != nil {
+		return err
+	}
+
+	err := c.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(utxoFlushKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.outputs = make(map[string]*cachedOutput)
+	c.usage = 0
+	c.batch = nil
+	c.dirty = false
+
+	return nil
+}
+
+// NOTE Discard drops whatever is not flushed, the database keeps the flush marker.
+// NOTE The cache can't be used afterwards
+func (c *CoinsCache) Discard() {
+	if c.batch != nil {
+		c.batch.Cancel()
+	}
+	c.outputs = make(map[string]*cachedOutput)
+	c.usage = 0
+}
@@ -0,0 +1,63 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoinsCache(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW := wallets[0]
+
+	chain, UTXO := newTestChain(t, "coinscache", alice)
+
+	cache, err := UTXO.NewCoinsCache(1 << 20)
+	assert.NoError(t, err)
+	UTXO.Cache = cache
+
+	// NOTE transactions are built from flushed outputs only, so one per flush
+	pay := NewTransaction(aliceW, bob, 5*Coin, UTXO)
+	block := chain.MineBlock([]*Transaction{CoinbaseTx(alice, ""), pay})
+	assert.NoError(t, UTXO.Update(block))
+
+	// NOTE nothing reached the database yet, Lookup sees the cache
+	_, found := UTXO.Lookup(pay.ID, 0)
+	assert.True(t, found)
+	assert.Equal(t, 1, UTXO.CountUnspentOuts())
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, UTXO.Update(chain.MineBlock([]*Transaction{CoinbaseTx(alice, "")})))
+	}
+	assert.NoError(t, cache.Flush())
+
+	flushed := UTXO.TxOutSetInfo()
+	assert.Equal(t, 4, flushed.Height)
+	assert.Equal(t, 6, flushed.Outputs)
+	assert.Equal(t, 5*Coin, balance(UTXO, bob))
+
+	// NOTE a budget this small flushes after every block
+	cache.Budget = 1
+	second := NewTransaction(aliceW, bob, 7*Coin, UTXO)
+	assert.NoError(t, UTXO.Update(chain.MineBlock([]*Transaction{CoinbaseTx(alice, ""), second})))
+	assert.Equal(t, 12*Coin, balance(UTXO, bob))
+
+	UTXO.Cache = nil
+	incremental := UTXO.TxOutSetInfo()
+	UTXO.Reindex()
+	assert.Equal(t, incremental, UTXO.TxOutSetInfo())
+
+	// NOTE blocks lost with an unflushed cache make the set rebuild on the next start
+	cache, err = UTXO.NewCoinsCache(0)
+	assert.NoError(t, err)
+	UTXO.Cache = cache
+	assert.NoError(t, UTXO.Update(chain.MineBlock([]*Transaction{CoinbaseTx(alice, "")})))
+	cache.Discard()
+	UTXO.Cache = nil
+
+	assert.Equal(t, incremental, UTXO.TxOutSetInfo())
+	assert.True(t, UTXO.Migrate())
+	assert.False(t, UTXO.Migrate())
+	assert.Equal(t, 6, UTXO.TxOutSetInfo().Height)
+}
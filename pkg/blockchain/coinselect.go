@@ -0,0 +1,214 @@
+// NOTE coin selection decides which unspent outputs pay for a transaction.
+// NOTE Grabbing outputs in database order until the amount is covered produces
+// NOTE change almost every time and slowly fragments a wallet into dust.
+// NOTE Strategies:
+// NOTE 	branch and bound - look for a set which matches the amount exactly (no change output)
+// NOTE 	largest first    - few inputs, keeps small outputs around
+// NOTE 	smallest first   - consolidation, eats small outputs first
+// NOTE 	oldest first     - spends by confirmation height
+
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const defaultBnBTries = 100000
+
+var (
+	ErrInsufficientFunds = errors.New("not enough funds")
+	errUnknownSelector   = errors.New("unknown coin selection strategy")
+)
+
+type (
+	// NOTE single unspent output owned by the wallet
+	SpendableOutput struct {
+		TxID   []byte
+		Out    int
+		Output TXO
+		Height int
+	}
+
+	CoinSelector interface {
+		// NOTE Select returns outputs which cover `target`, or ErrInsufficientFunds
+		Select(candidates []SpendableOutput, target Amount) ([]SpendableOutput, error)
+	}
+
+	BranchAndBound struct {
+		// NOTE overshoot which is still treated as "no change", 0 means exact match only
+		CostOfChange Amount
+		// NOTE search budget, defaults to defaultBnBTries
+		MaxTries int
+		// NOTE used when no changeless set exists, defaults to LargestFirst
+		Fallback CoinSelector
+	}
+
+	LargestFirst  struct{}
+	SmallestFirst struct{}
+	OldestFirst   struct{}
+)
+
+var DefaultCoinSelector CoinSelector = BranchAndBound{}
+
+// NOTE names accepted by the `send -strategy` flag
+func SelectorByName(name string) (CoinSelector, error) {
+	switch name {
+	case "", "bnb":
+		return BranchAndBound{}, nil
+	case "largest":
+		return LargestFirst{}, nil
+	case "smallest":
+		return SmallestFirst{}, nil
+	case "oldest":
+		return OldestFirst{}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", errUnknownSelector, name)
+}
+
+// NOTE values in the set are validated and their total is bound by the issued supply,
+// NOTE so plain addition can't overflow here
+func sumOutputs(outs []SpendableOutput) Amount {
+	var total Amount
+
+	for _, out := range outs {
+		total += out.Output.Value
+	}
+
+	return total
+}
+
+// NOTE take outputs in the given order until target is covered
+func accumulate(sorted []SpendableOutput, target Amount) ([]SpendableOutput, error) {
+	var (
+		selected []SpendableOutput
+		acc      Amount
+	)
+
+	for _, out := range sorted {
+		if acc >= target {
+			break
+		}
+
+		selected = append(selected, out)
+		acc += out.Output.Value
+	}
+
+	if acc < target {
+		return nil, ErrInsufficientFunds
+	}
+
+	return selected, nil
+}
+
+func sortedCopy(candidates []SpendableOutput, less func(a, b SpendableOutput) bool) []SpendableOutput {
+	sorted := append([]SpendableOutput{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+
+	return sorted
+}
+
+func (LargestFirst) Select(candidates []SpendableOutput, target Amount) ([]SpendableOutput, error) {
+	return accumulate(sortedCopy(candidates, func(a, b SpendableOutput) bool {
+		return a.Output.Value > b.Output.Value
+	}), target)
+}
+
+func (SmallestFirst) Select(candidates []SpendableOutput, target Amount) ([]SpendableOutput, error) {
+	return accumulate(sortedCopy(candidates, func(a, b SpendableOutput) bool {
+		return a.Output.Value < b.Output.Value
+	}), target)
+}
+
+func (OldestFirst) Select(candidates []SpendableOutput, target Amount) ([]SpendableOutput, error) {
+	return accumulate(sortedCopy(candidates, func(a, b SpendableOutput) bool {
+		return a.Height < b.Height
+	}), target)
+}
+
+// NOTE depth first search over "take / skip" for every output, sorted from the largest.
+// NOTE A branch is cut when it overshoots target+CostOfChange or when the rest
+// NOTE can't reach target anymore. The set with the least overshoot wins
+func (b BranchAndBound) Select(candidates []SpendableOutput, target Amount) ([]SpendableOutput, error) {
+	if sumOutputs(candidates) < target {
+		return nil, ErrInsufficientFunds
+	}
+
+	maxTries := b.MaxTries
+	if maxTries <= 0 {
+		maxTries = defaultBnBTries
+	}
+
+	sorted := sortedCopy(candidates, func(a, b SpendableOutput) bool {
+		return a.Output.Value > b.Output.Value
+	})
+
+	var (
+		tries     int
+		current   []int
+		best      []int
+		bestWaste = Amount(math.MaxInt64)
+	)
+
+	var search func(i int, acc, remaining Amount) bool
+	search = func(i int, acc, remaining Amount) bool {
+		tries++
+		if tries > maxTries || acc > target+b.CostOfChange {
+			return tries > maxTries
+		}
+
+		if acc >= target {
+			if waste := acc - target; waste < bestWaste {
+				bestWaste = waste
+				best = append(best[:0], current...)
+			}
+
+			// NOTE exact match can't be beaten
+			return bestWaste == 0
+		}
+
+		if i == len(sorted) || acc+remaining < target {
+			return false
+		}
+
+		value := sorted[i].Output.Value
+
+		current = append(current, i)
+		if search(i+1, acc+value, remaining-value) {
+			return true
+		}
+		current = current[:len(current)-1]
+
+		// NOTE skipping an output also skips its equal neighbours, they would give the same sets
+		next, rest := i+1, remaining-value
+		for next < len(sorted) && sorted[next].Output.Value == value {
+			rest -= value
+			next++
+		}
+
+		return search(next, acc, rest)
+	}
+
+	search(0, 0, sumOutputs(sorted))
+
+	if best == nil {
+		fallback := b.Fallback
+		if fallback == nil {
+			fallback = LargestFirst{}
+		}
+
+		return fallback.Select(candidates, target)
+	}
+
+	selected := make([]SpendableOutput, 0, len(best))
+	for _, i := range best {
+		selected = append(selected, sorted[i])
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,53 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func coinCandidates(values ...Amount) []SpendableOutput {
+	var outs []SpendableOutput
+
+	for i, value := range values {
+		outs = append(outs, SpendableOutput{TxID: []byte(fmt.Sprintf("tx%d", i)), Output: TXO{Value: value}, Height: i})
+	}
+
+	return outs
+}
+
+func TestBranchAndBound(t *testing.T) {
+	candidates := coinCandidates(5*Coin, 4*Coin, 3*Coin, 2*Coin, Coin)
+
+	// NOTE exact matches, no change output needed
+	for _, target := range []Amount{Coin, 6 * Coin, 10 * Coin, 15 * Coin} {
+		selected, err := BranchAndBound{}.Select(candidates, target)
+		assert.NoError(t, err)
+		assert.Equal(t, target, sumOutputs(selected), "target %s", target)
+	}
+
+	// NOTE an overshoot up to the cost of change still counts
+	selected, err := BranchAndBound{CostOfChange: Coin / 2}.Select(candidates, 6*Coin+Coin/2)
+	assert.NoError(t, err)
+	assert.Equal(t, 7*Coin, sumOutputs(selected))
+
+	// NOTE no changeless set: largest first by default, or the given fallback
+	selected, err = BranchAndBound{}.Select(candidates, 7*Coin+Coin/2)
+	assert.NoError(t, err)
+	assert.Equal(t, 9*Coin, sumOutputs(selected))
+	assert.Len(t, selected, 2)
+
+	selected, err = BranchAndBound{Fallback: SmallestFirst{}}.Select(candidates, 7*Coin+Coin/2)
+	assert.NoError(t, err)
+	assert.Equal(t, 10*Coin, sumOutputs(selected))
+	assert.Len(t, selected, 4)
+
+	// NOTE out of tries before the match is found
+	selected, err = BranchAndBound{MaxTries: 1}.Select(candidates, 6*Coin)
+	assert.NoError(t, err)
+	assert.Equal(t, 9*Coin, sumOutputs(selected))
+
+	_, err = BranchAndBound{}.Select(candidates, 16*Coin)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+}
@@ -0,0 +1,200 @@
+// NOTE data carrier outputs anchor arbitrary bytes (document hashes, agreement references)
+// NOTE in a transaction. They hold no value and can never be spent, therefore the
+// NOTE UTXO set skips them. Instead they are kept in a separate index, where the key
+// NOTE starts with the payload itself, so lookups by payload prefix are a plain prefix scan
+
+package blockchain
+
+import (
+	"blockchain/pkg/utils"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+const MaxDataCarrierSize = 80
+
+var (
+	dataPrefix = []byte("data-")
+
+	errDataCarrierSize  = fmt.Errorf("data carrier payload must be 1-%d bytes", MaxDataCarrierSize)
+	errDataCarrierValue = errors.New("data carrier output can not hold value or a lock")
+)
+
+type DataCarrier struct {
+	TxID      []byte
+	Out       int
+	Height    int
+	BlockHash []byte
+	Data      []byte
+}
+
+// NOTE NewDataTXO creates a provably unspendable output carrying `data`
+func NewDataTXO(data []byte) (*TXO, error) {
+	out := &TXO{Data: data}
+
+	if err := out.checkDataCarrier(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (out *TXO) IsDataCarrier() bool {
+	return len(out.Data) > 0
+}
+
+func (out *TXO) checkDataCarrier() error {
+	if len(out.Data) == 0 || len(out.Data) > MaxDataCarrierSize {
+		return errDataCarrierSize
+	}
+	if out.Value != 0 || len(out.PubkeyHash) != 0 || out.HTLC != nil || out.Channel != nil {
+		return errDataCarrierValue
+	}
+
+	return nil
+}
+
+// NOTE CheckOutputs validates outputs which need no chain context:
+// NOTE data carriers, hash time locks, channel locks, assets, value ranges and the overflow of their sums
+func (tx *Transaction) CheckOutputs() error {
+	if tx.Issue != nil {
+		if err := tx.Issue.check(); err != nil || tx.IsCoinbase() {
+			return fmt.Errorf("transaction %x: %w", tx.ID, errAssetName)
+		}
+	}
+
+	for outIdx, out := range tx.Output {
+		if out.IsDataCarrier() {
+			if err := out.checkDataCarrier(); err != nil {
+				return fmt.Errorf("transaction %x, output %d: %w", tx.ID, outIdx, err)
+			}
+		}
+
+		if out.HTLC != nil {
+			if err := out.HTLC.check(); err != nil || len(out.PubkeyHash) != 0 || out.IsDataCarrier() {
+				return fmt.Errorf("transaction %x, output %d: %w", tx.ID, outIdx, errHTLC)
+			}
+		}
+
+		if out.Channel != nil {
+			if err := out.Channel.check(); err != nil || len(out.PubkeyHash) != 0 || out.HTLC != nil || out.IsDataCarrier() {
+				return fmt.Errorf("transaction %x, output %d: %w", tx.ID, outIdx, errChannelLock)
+			}
+		}
+
+		if out.IsAsset() {
+			if err := out.checkAsset(); err != nil || tx.IsCoinbase() {
+				return fmt.Errorf("transaction %x, output %d: %w", tx.ID, outIdx, errAssetOutput)
+			}
+		}
+	}
+
+	if _, err := sumByAsset(tx.Output); err != nil {
+		return fmt.Errorf("transaction %x outputs: %w", tx.ID, err)
+	}
+
+	return nil
+}
+
+// NOTE key: prefix + payload + txid + output index
+func dataCarrierKey(data, txID []byte, out int) []byte {
+	key := make([]byte, 0, len(dataPrefix)+len(data)+len(txID)+4)
+	key = append(key, dataPrefix...)
+	key = append(key, data...)
+	key = append(key, txID...)
+
+	return binary.BigEndian.AppendUint32(key, uint32(out))
+}
+
+func (d DataCarrier) Serialize() []byte {
+	var buff bytes.Buffer
+
+	err := gob.NewEncoder(&buff).Encode(d)
+	utils.DisplayErr(err)
+
+	return buff.Bytes()
+}
+
+func DeserializeDataCarrier(data []byte) DataCarrier {
+	var d DataCarrier
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&d)
+	utils.DisplayErr(err)
+
+	return d
+}
+
+func indexDataCarriers(txn kvWriter, block *Block) error {
+	for _, tx := range block.Transactions {
+		for outIdx, out := range tx.Output {
+			if !out.IsDataCarrier() {
+				continue
+			}
+
+			record := DataCarrier{tx.ID, outIdx, block.Height, block.Hash, out.Data}
+			if err := txn.Set(dataCarrierKey(out.Data, tx.ID, outIdx), record.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// NOTE rebuild the data carrier index from the whole chain, in batches
+func (chain *Blockchain) ReindexDataCarriers() {
+	u := UnspentTransactionSET{Blockchain: chain}
+	u.DeleteUnspent(dataPrefix)
+
+	batch := chain.Database.NewWriteBatch()
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		if err := indexDataCarriers(batch, block); err != nil {
+			batch.Cancel()
+			utils.DisplayErr(err)
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	utils.DisplayErr(batch.Flush())
+}
+
+// NOTE FindDataCarriers returns every indexed payload starting with `prefix`
+func (chain *Blockchain) FindDataCarriers(prefix []byte) ([]DataCarrier, error) {
+	var found []DataCarrier
+
+	seek := append(append([]byte{}, dataPrefix...), prefix...)
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(seek); it.ValidForPrefix(seek); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			// NOTE a shorter payload followed by its txid can also match the prefix
+			record := DeserializeDataCarrier(v)
+			if bytes.HasPrefix(record.Data, prefix) {
+				found = append(found, record)
+			}
+		}
+
+		return nil
+	})
+
+	return found, err
+}
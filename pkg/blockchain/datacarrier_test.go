@@ -0,0 +1,94 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataCarrierSize(t *testing.T) {
+	_, err := NewDataTXO(nil)
+	assert.ErrorIs(t, err, errDataCarrierSize)
+
+	_, err = NewDataTXO(bytes.Repeat([]byte{1}, MaxDataCarrierSize+1))
+	assert.ErrorIs(t, err, errDataCarrierSize)
+
+	out, err := NewDataTXO(bytes.Repeat([]byte{1}, MaxDataCarrierSize))
+	assert.NoError(t, err)
+	assert.True(t, out.IsDataCarrier())
+
+	// NOTE the payload can't come with value
+	tx := &Transaction{Output: []TXO{{Value: Coin, Data: []byte("doc")}}}
+	assert.ErrorIs(t, tx.CheckOutputs(), errDataCarrierValue)
+
+	tx = &Transaction{Output: []TXO{{Data: bytes.Repeat([]byte{1}, MaxDataCarrierSize+1)}}}
+	assert.ErrorIs(t, tx.CheckOutputs(), errDataCarrierSize)
+}
+
+func dataCarrierOut(tx *Transaction) int {
+	for outIdx, out := range tx.Output {
+		if out.IsDataCarrier() {
+			return outIdx
+		}
+	}
+
+	return -1
+}
+
+func TestDataCarrierIndex(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW := wallets[0]
+
+	chain, UTXO := newTestChain(t, "datacarrier", alice)
+
+	first := NewDataTransaction(aliceW, bob, Coin, []byte("doc:alpha"), UTXO)
+	mineTestBlock(chain, UTXO, alice, first)
+	second := NewDataTransaction(aliceW, bob, Coin, []byte("doc:beta"), UTXO)
+	mineTestBlock(chain, UTXO, alice, second)
+	block := chain.GetBlock(chain.LastHash)
+
+	// NOTE payment, change and payload, the payload never enters the set
+	out := dataCarrierOut(first)
+	assert.Len(t, first.Output, 3)
+	assert.NotEqual(t, -1, out)
+	_, found := UTXO.Lookup(first.ID, out)
+	assert.False(t, found)
+	// NOTE each block: coinbase, payment and change, minus the spent output
+	assert.Equal(t, 1+2*(3-1), UTXO.CountUnspentOuts())
+
+	records, err := chain.FindDataCarriers([]byte("doc:"))
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	records, err = chain.FindDataCarriers([]byte("doc:a"))
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, DataCarrier{first.ID, out, 1, records[0].BlockHash, []byte("doc:alpha")}, records[0])
+		assert.Equal(t, chain.GetBlock(block.PrevHash).Hash, records[0].BlockHash)
+	}
+
+	records, err = chain.FindDataCarriers([]byte("memo"))
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	// NOTE Disconnect drops the payloads of the block, Update brings them back
+	assert.NoError(t, UTXO.Disconnect(&block))
+	records, err = chain.FindDataCarriers([]byte("doc:"))
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	assert.NoError(t, UTXO.Update(&block))
+	records, err = chain.FindDataCarriers([]byte("doc:b"))
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, DataCarrier{second.ID, dataCarrierOut(second), 2, block.Hash, []byte("doc:beta")}, records[0])
+	}
+
+	// NOTE and the index agrees with a rebuild from the chain
+	chain.ReindexDataCarriers()
+	records, err = chain.FindDataCarriers([]byte("doc:"))
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+}
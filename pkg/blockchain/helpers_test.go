@@ -0,0 +1,55 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/utils"
+	"path/filepath"
+	"testing"
+)
+
+// NOTE points dbPath at a directory of the test and makes `n` wallets, their
+// NOTE addresses and wallets come in the same order
+func newTestWallets(t *testing.T, n int) ([]string, []*wallet.Wallet) {
+	path := dbPath
+	t.Cleanup(func() { dbPath = path })
+	dbPath = filepath.Join(t.TempDir(), "blocks_%s") + "/"
+
+	wallets := wallet.Wallets{Wallets: make(map[string]*wallet.Wallet)}
+
+	var (
+		addresses []string
+		owners    []*wallet.Wallet
+	)
+	for i := 0; i < n; i++ {
+		address := wallets.AddWallet()
+		addresses = append(addresses, address)
+		owners = append(owners, wallets.GetWallet(address))
+	}
+
+	return addresses, owners
+}
+
+func newTestChain(t *testing.T, nodeId, address string) (*Blockchain, *UnspentTransactionSET) {
+	chain := InitBlockchain(address, nodeId)
+	t.Cleanup(func() { chain.Database.Close() })
+
+	UTXO := &UnspentTransactionSET{Blockchain: chain}
+	UTXO.Reindex()
+
+	return chain, UTXO
+}
+
+func mineTestBlock(chain *Blockchain, UTXO *UnspentTransactionSET, miner string, txs ...*Transaction) {
+	block := chain.MineBlock(append([]*Transaction{CoinbaseTx(miner, "")}, txs...))
+	utils.DisplayErr(UTXO.Update(block))
+}
+
+func balance(UTXO *UnspentTransactionSET, address string) Amount {
+	var total Amount
+
+	for _, out := range UTXO.SpendableOutputs(wallet.AddressPubKeyHash(address)) {
+		total += out.Output.Value
+	}
+
+	return total
+}
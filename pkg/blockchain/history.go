@@ -0,0 +1,235 @@
+// NOTE address history - what every confirmed transaction did to an address: amounts it
+// NOTE received and sent in the native coin, who paid or got paid, how deep it is.
+// NOTE Amounts spent come from the undo data of the block, so inputs are not looked up
+// NOTE one by one. Only outputs locked to the plain pubkey hash count in the amounts,
+// NOTE hash time locks and channels show up as counterparties
+
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"bytes"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+type HistoryEntry struct {
+	TxID      []byte
+	Height    int
+	Timestamp int64
+	Coinbase  bool
+	Received  Amount
+	Sent      Amount
+	// NOTE addresses paid when the address pays, paying it otherwise
+	Counterparties []string
+	Confirmations  int
+}
+
+// NOTE Net is what the transaction added to the balance, negative when the address paid
+func (e HistoryEntry) Net() Amount {
+	return e.Received - e.Sent
+}
+
+// NOTE History lists transactions of pubKeyHash newest first, skips `skip` and returns at
+// NOTE most `count` of them (all when count <= 0), along with how many there are in total.
+// NOTE Reads the address index when it is enabled, walks the chain otherwise
+func (u UnspentTransactionSET) History(pubKeyHash []byte, skip, count int) ([]HistoryEntry, int, error) {
+	var txs []AddressTransaction
+
+	if u.AddressIndexEnabled() {
+		txs = u.AddressTransactions(pubKeyHash)
+		for i, j := 0, len(txs)-1; i < j; i, j = i+1, j-1 {
+			txs[i], txs[j] = txs[j], txs[i]
+		}
+	} else {
+		var err error
+		if txs, err = u.Blockchain.addressTransactions(pubKeyHash); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	total := len(txs)
+	if skip >= total {
+		return nil, total, nil
+	}
+
+	txs = txs[skip:]
+	if count > 0 && count < len(txs) {
+		txs = txs[:count]
+	}
+
+	tip, _ := u.Blockchain.GetBestHeightAndLastHash()
+
+	var (
+		history []HistoryEntry
+		block   *Block
+		spent   map[string]UnspentOutput
+	)
+
+	for _, found := range txs {
+		// NOTE entries of the same block are next to each other
+		if block == nil || block.Height != found.Height {
+			var err error
+			if block, err = u.Blockchain.BlockAtHeight(found.Height); err != nil {
+				return nil, total, err
+			}
+			if block.IsPruned() {
+				return nil, total, fmt.Errorf("%w: block %x at height %d", errPruned, block.Hash, block.Height)
+			}
+			if spent, err = u.Blockchain.spentOutputs(block); err != nil {
+				return nil, total, err
+			}
+		}
+
+		tx := block.transaction(found.TxID)
+		if tx == nil {
+			return nil, total, fmt.Errorf("transaction %x is not in block %x", found.TxID, block.Hash)
+		}
+
+		entry := newHistoryEntry(pubKeyHash, tx, spent)
+		entry.Height = block.Height
+		entry.Timestamp = block.Timestamp
+		entry.Confirmations = tip - block.Height + 1
+
+		history = append(history, entry)
+	}
+
+	return history, total, nil
+}
+
+// NOTE the main chain walk History falls back on without the address index
+func (chain *Blockchain) addressTransactions(pubKeyHash []byte) ([]AddressTransaction, error) {
+	var found []AddressTransaction
+
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		if block.IsPruned() {
+			return nil, fmt.Errorf("%w: block %x at height %d", errPruned, block.Hash, block.Height)
+		}
+
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			tx := block.Transactions[i]
+
+			for _, address := range tx.addresses() {
+				if bytes.Equal(address, pubKeyHash) {
+					found = append(found, AddressTransaction{TxID: tx.ID, Height: block.Height})
+					break
+				}
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return found, nil
+}
+
+func (b *Block) transaction(txID []byte) *Transaction {
+	for _, tx := range b.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			return tx
+		}
+	}
+
+	return nil
+}
+
+// NOTE outputs the block spends by outpoint key, from its undo data. Blocks below a loaded
+// NOTE snapshot have none, their inputs are looked up on the chain instead
+func (chain *Blockchain) spentOutputs(block *Block) (map[string]UnspentOutput, error) {
+	spent := make(map[string]UnspentOutput)
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(undoKey(block.Hash))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		for _, out := range deserializeUndo(v) {
+			spent[string(outpointKey(out.TxID, out.Out))] = out.Unspent
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, in := range tx.Inputs {
+			key := string(outpointKey(in.ID, in.Out))
+			if _, ok := spent[key]; ok {
+				continue
+			}
+
+			prev, err := chain.FindTransaction(in.ID)
+			if err != nil {
+				return nil, err
+			}
+			if in.Out >= len(prev.Output) {
+				return nil, fmt.Errorf("transaction %x spends unknown output %x:%d", tx.ID, in.ID, in.Out)
+			}
+
+			spent[key] = UnspentOutput{Output: prev.Output[in.Out]}
+		}
+	}
+
+	return spent, nil
+}
+
+func newHistoryEntry(pubKeyHash []byte, tx *Transaction, spent map[string]UnspentOutput) HistoryEntry {
+	entry := HistoryEntry{TxID: tx.ID, Coinbase: tx.IsCoinbase()}
+
+	var payers, payees [][]byte
+
+	if !entry.Coinbase {
+		for _, in := range tx.Inputs {
+			out := spent[string(outpointKey(in.ID, in.Out))].Output
+
+			if out.IsLockedWithKey(pubKeyHash) && out.Asset == nil {
+				entry.Sent += out.Value
+			}
+			payers = append(payers, out.owners()...)
+		}
+	}
+
+	for _, out := range tx.Output {
+		if out.IsLockedWithKey(pubKeyHash) && out.Asset == nil {
+			entry.Received += out.Value
+		}
+		payees = append(payees, out.owners()...)
+	}
+
+	others := payers
+	if entry.Sent > 0 {
+		others = payees
+	}
+
+	seen := map[string]bool{string(pubKeyHash): true}
+	for _, other := range others {
+		if !seen[string(other)] {
+			seen[string(other)] = true
+			entry.Counterparties = append(entry.Counterparties, wallet.PubKeyHashAddress(other))
+		}
+	}
+
+	return entry
+}
@@ -0,0 +1,47 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 3)
+	alice, bob, miner := addresses[0], addresses[1], addresses[2]
+	aliceW, bobW := wallets[0], wallets[1]
+
+	chain, UTXO := newTestChain(t, "history", alice)
+	mineTestBlock(chain, UTXO, miner, NewTransaction(aliceW, bob, 5*Coin, UTXO))
+	mineTestBlock(chain, UTXO, miner, NewTransaction(bobW, alice, 2*Coin, UTXO))
+	mineTestBlock(chain, UTXO, miner)
+
+	history, total, err := UTXO.History(wallet.AddressPubKeyHash(bob), 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	// NOTE newest first
+	assert.Equal(t, 2, history[0].Height)
+	assert.Equal(t, -2*Coin, history[0].Net())
+	assert.Equal(t, []string{alice}, history[0].Counterparties)
+	assert.Equal(t, 2, history[0].Confirmations)
+
+	assert.Equal(t, 1, history[1].Height)
+	assert.Equal(t, 5*Coin, history[1].Net())
+	assert.Equal(t, []string{alice}, history[1].Counterparties)
+	assert.Equal(t, 3, history[1].Confirmations)
+
+	page, total, err := UTXO.History(wallet.AddressPubKeyHash(alice), 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Len(t, page, 1)
+	assert.Equal(t, -5*Coin, page[0].Net())
+	assert.Equal(t, []string{bob}, page[0].Counterparties)
+
+	// NOTE the address index gives the same answer
+	UTXO.EnableAddressIndex()
+	indexed, _, err := UTXO.History(wallet.AddressPubKeyHash(bob), 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, history, indexed)
+}
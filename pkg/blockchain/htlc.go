@@ -0,0 +1,214 @@
+// NOTE hash time locked contract (HTLC) - output which can be spent in two ways:
+// NOTE 	redeem - recipient shows the secret whose SHA-256 is Hash, any time until refunded
+// NOTE 	refund - after Timeout (block height) the refunder takes the coins back
+// NOTE Atomic swap between two chains with the same secret:
+// NOTE 	1. A picks a secret and locks coins for B on chain 1, long timeout
+// NOTE 	2. B locks coins for A on chain 2 with the same hash, shorter timeout
+// NOTE 	3. A redeems on chain 2 and by doing so reveals the secret
+// NOTE 	4. B reads the secret from A's redeem and redeems on chain 1
+// NOTE If anybody stops halfway, both sides get their coins back after the timeouts
+
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/sha"
+	"blockchain/pkg/utils"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+const SwapSecretSize = 32
+
+var (
+	errHTLC         = errors.New("malformed hash time lock")
+	errHTLCSecret   = errors.New("secret does not match the hash of the contract")
+	errHTLCContract = errors.New("output is not a hash time locked contract")
+)
+
+type HashTimeLock struct {
+	// NOTE SHA-256 of the secret
+	Hash []byte
+	// NOTE pubkey hash which redeems with the secret
+	Recipient []byte
+	// NOTE pubkey hash which takes the coins back after Timeout
+	Refund []byte
+	// NOTE block height from which the refund is possible
+	Timeout int
+}
+
+func HTLCHash(secret []byte) []byte {
+	hash := sha.ComputeHash(secret)
+
+	return hash[:]
+}
+
+// NOTE NewSwapSecret returns a random secret and its hash
+func NewSwapSecret() ([]byte, []byte) {
+	secret := make([]byte, SwapSecretSize)
+	_, err := rand.Read(secret)
+	utils.DisplayErr(err)
+
+	return secret, HTLCHash(secret)
+}
+
+func NewHTLCTXO(value Amount, hash []byte, recipient, refund string, timeout int) *TXO {
+	return &TXO{
+		Value: value,
+		HTLC: &HashTimeLock{
+			Hash:      hash,
+			Recipient: wallet.AddressPubKeyHash(recipient),
+			Refund:    wallet.AddressPubKeyHash(refund),
+			Timeout:   timeout,
+		},
+	}
+}
+
+func (l *HashTimeLock) check() error {
+	if len(l.Hash) != 32 || len(l.Recipient) == 0 || len(l.Refund) == 0 || l.Timeout <= 0 {
+		return errHTLC
+	}
+
+	return nil
+}
+
+func (l *HashTimeLock) serialize() []byte {
+	return bytes.Join([][]byte{l.Hash, l.Recipient, l.Refund, utils.ToHex(int64(l.Timeout))}, []byte{})
+}
+
+// NOTE IsFinal tells whether the transaction may be mined in a block at `height`
+func (t *Transaction) IsFinal(height int) bool {
+	return t.LockTime <= height
+}
+
+// NOTE NewHTLCTransaction locks `amount` of the wallet for `recipient` under `hash`.
+// NOTE The wallet itself is the refunder once the chain reaches height `timeout`
+func NewHTLCTransaction(w *wallet.Wallet, recipient string, amount Amount, hash []byte, timeout int, UTXO *UnspentTransactionSET) *Transaction {
+	if len(hash) != 32 {
+		utils.DisplayErr(errHTLC)
+	}
+
+	refund := string(w.Address())
+	contract := NewHTLCTXO(amount, hash, recipient, refund, timeout)
+
+	return buildTransaction(w, []TXO{*contract}, nil, nil, UTXO)
+}
+
+// NOTE NewHTLCRedeemTransaction spends contract output `out` of transaction `contractID`
+// NOTE to the wallet, revealing `secret` in the witness
+func NewHTLCRedeemTransaction(w *wallet.Wallet, contractID []byte, out int, contract TXO, secret []byte) (*Transaction, error) {
+	if contract.HTLC == nil {
+		return nil, errHTLCContract
+	}
+	if !bytes.Equal(HTLCHash(secret), contract.HTLC.Hash) {
+		return nil, errHTLCSecret
+	}
+
+	in := TXI{ID: contractID, Out: out, PubKey: w.PublicKey, Witness: [][]byte{secret}}
+
+	return spendContract(w, in, contract, 0)
+}
+
+// NOTE NewHTLCRefundTransaction takes the coins back, it can be mined from the timeout height on
+func NewHTLCRefundTransaction(w *wallet.Wallet, contractID []byte, out int, contract TXO) (*Transaction, error) {
+	if contract.HTLC == nil {
+		return nil, errHTLCContract
+	}
+
+	in := TXI{ID: contractID, Out: out, PubKey: w.PublicKey}
+
+	return spendContract(w, in, contract, contract.HTLC.Timeout)
+}
+
+func spendContract(w *wallet.Wallet, in TXI, contract TXO, lockTime int) (*Transaction, error) {
+	tx := Transaction{
+		Inputs:   []TXI{in},
+		Output:   []TXO{*NewTXO(contract.Value, string(w.Address()))},
+		LockTime: lockTime,
+	}
+
+	if err := tx.SignInput(w.PrivateKey, 0, contract, SigHashAll); err != nil {
+		return nil, err
+	}
+	tx.ID = tx.Hash()
+
+	if !tx.VerifyInput(0, contract) {
+		return nil, fmt.Errorf("%w: wallet can't spend this contract", errHTLCContract)
+	}
+
+	return &tx, nil
+}
+
+// NOTE ExtractHTLCSecret reads the secret from a transaction which redeemed the contract
+func ExtractHTLCSecret(tx *Transaction, contractID []byte, out int, contract TXO) ([]byte, bool) {
+	if contract.HTLC == nil {
+		return nil, false
+	}
+
+	for _, in := range tx.Inputs {
+		if !bytes.Equal(in.ID, contractID) || in.Out != out || len(in.Witness) != 1 {
+			continue
+		}
+
+		if bytes.Equal(HTLCHash(in.Witness[0]), contract.HTLC.Hash) {
+			return in.Witness[0], true
+		}
+	}
+
+	return nil, false
+}
+
+// NOTE one witness item (the secret) - redeem path, no witness - refund path
+func (t *Transaction) verifyHashTimeLock(inIdx int, prevOut TXO, verify sigVerifier) bool {
+	in := t.Inputs[inIdx]
+	lock := prevOut.HTLC
+	signer := wallet.PublicKey(in.PubKey)
+
+	switch len(in.Witness) {
+	case 1:
+		if !bytes.Equal(HTLCHash(in.Witness[0]), lock.Hash) || !bytes.Equal(signer, lock.Recipient) {
+			return false
+		}
+	case 0:
+		// NOTE LockTime keeps the refund out of blocks below Timeout
+		if t.LockTime < lock.Timeout || !bytes.Equal(signer, lock.Refund) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	return t.checkSignature(inIdx, prevOut, in.Signature, in.PubKey, verify)
+}
+
+// NOTE FindHTLCSecret looks for a redeem of the contract in the chain and returns the revealed secret
+func (chain *Blockchain) FindHTLCSecret(contractID []byte, out int) ([]byte, error) {
+	contractTx, err := chain.FindTransaction(contractID)
+	if err != nil {
+		return nil, err
+	}
+	if out < 0 || out >= len(contractTx.Output) || contractTx.Output[out].HTLC == nil {
+		return nil, errHTLCContract
+	}
+
+	contract := contractTx.Output[out]
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			if secret, ok := ExtractHTLCSecret(tx, contractID, out, contract); ok {
+				return secret, nil
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("%w: contract %x:%d is not redeemed yet", errHTLCSecret, contractID, out)
+}
@@ -0,0 +1,83 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NOTE alice trades 5 coins of chain A for 3 coins of chain B owned by bob
+func TestAtomicSwap(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW, bobW := wallets[0], wallets[1]
+
+	chainA, utxoA := newTestChain(t, "swap_a", alice)
+	chainB, utxoB := newTestChain(t, "swap_b", bob)
+
+	// NOTE 1. alice locks coins for bob on A, refundable from height 10
+	secret, hash := NewSwapSecret()
+	lockA := NewHTLCTransaction(aliceW, bob, 5*Coin, hash, 10, utxoA)
+	mineTestBlock(chainA, utxoA, alice, lockA)
+
+	// NOTE 2. bob sees the hash and locks coins for alice on B with a shorter timeout
+	contractA := lockA.Output[0]
+	lockB := NewHTLCTransaction(bobW, alice, 3*Coin, contractA.HTLC.Hash, 5, utxoB)
+	mineTestBlock(chainB, utxoB, bob, lockB)
+
+	// NOTE bob can't take his coins back before the timeout
+	refundB, err := NewHTLCRefundTransaction(bobW, lockB.ID, 0, lockB.Output[0])
+	assert.NoError(t, err)
+	assert.Panics(t, func() { mineTestBlock(chainB, utxoB, bob, refundB) })
+
+	// NOTE nobody redeems without the secret
+	_, err = NewHTLCRedeemTransaction(aliceW, lockB.ID, 0, lockB.Output[0], []byte("guess"))
+	assert.Error(t, err)
+
+	// NOTE 3. alice redeems on B, revealing the secret
+	redeemB, err := NewHTLCRedeemTransaction(aliceW, lockB.ID, 0, lockB.Output[0], secret)
+	assert.NoError(t, err)
+	mineTestBlock(chainB, utxoB, bob, redeemB)
+
+	// NOTE 4. bob reads the secret from chain B and redeems on A
+	revealed, err := chainB.FindHTLCSecret(lockB.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, secret, revealed)
+
+	redeemA, err := NewHTLCRedeemTransaction(bobW, lockA.ID, 0, contractA, revealed)
+	assert.NoError(t, err)
+	mineTestBlock(chainA, utxoA, alice, redeemA)
+
+	assert.Equal(t, 5*Coin, balance(utxoA, bob))
+	assert.Equal(t, 3*Coin, balance(utxoB, alice))
+	assert.Equal(t, 3*Subsidy-5*Coin, balance(utxoA, alice))
+	assert.Equal(t, 3*Subsidy-3*Coin, balance(utxoB, bob))
+}
+
+func TestHTLCRefund(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW, bobW := wallets[0], wallets[1]
+
+	chain, UTXO := newTestChain(t, "refund", alice)
+
+	_, hash := NewSwapSecret()
+	lock := NewHTLCTransaction(aliceW, bob, 5*Coin, hash, 3, UTXO)
+	mineTestBlock(chain, UTXO, alice, lock)
+
+	// NOTE the recipient can't use the refund path
+	_, err := NewHTLCRefundTransaction(bobW, lock.ID, 0, lock.Output[0])
+	assert.Error(t, err)
+
+	refund, err := NewHTLCRefundTransaction(aliceW, lock.ID, 0, lock.Output[0])
+	assert.NoError(t, err)
+	assert.False(t, chain.VerifyTransaction(refund))
+
+	// NOTE height 2 is mined, the refund fits into block 3
+	mineTestBlock(chain, UTXO, alice)
+	assert.True(t, chain.VerifyTransaction(refund))
+	mineTestBlock(chain, UTXO, alice, refund)
+
+	assert.Equal(t, 4*Subsidy, balance(UTXO, alice))
+	assert.Equal(t, Amount(0), balance(UTXO, bob))
+}
@@ -7,9 +7,13 @@ import (
 )
 
 func (chain *Blockchain) Iterator() *BlockchainIterator {
-	iter := &BlockchainIterator{chain.LastHash, chain.Database}
+	return chain.iteratorAt(chain.LastHash)
+}
+
+func (chain *Blockchain) iteratorAt(hash []byte) *BlockchainIterator {
+	_, pending := chain.SnapshotPending()
 
-	return iter
+	return &BlockchainIterator{CurrentHash: hash, Database: chain.Database, snapshot: pending}
 }
 
 func (iter *BlockchainIterator) Next() *Block {
@@ -25,6 +29,15 @@ func (iter *BlockchainIterator) Next() *Block {
 		})
 		block = DeserializeBlock(encodedBlock)
 
+		// NOTE a node started from a snapshot has no history below it until the download
+		// NOTE is done, the walk ends at the oldest block present as if it was genesis.
+		// NOTE Any other node is missing a block only when its database is broken
+		if iter.snapshot && len(block.PrevHash) > 0 {
+			if _, err := txn.Get(block.PrevHash); err == badger.ErrKeyNotFound {
+				block.PrevHash = nil
+			}
+		}
+
 		return err
 	})
 	utils.DisplayErr(err)
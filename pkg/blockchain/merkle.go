@@ -12,13 +12,34 @@
 // *I use words "nodes" and "blocks" as synonyms to avoid tautology
 // *look at image at image folder
 
+// NOTE Leaves and inner nodes are hashed with different prefixes, so an inner node
+// NOTE can't pass for a leaf (second preimage). A level with an odd number of nodes
+// NOTE gets its last node duplicated, every leaf is then as deep as any other
+
 package blockchain
 
-import "blockchain/pkg/sha"
+import (
+	"blockchain/pkg/sha"
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+const (
+	merkleLeafPrefix  byte = 0x00
+	merkleInnerPrefix byte = 0x01
+
+	// NOTE blocks with at least that many leaves have them hashed in parallel,
+	// NOTE a batch at a time so memory doesn't grow with the block
+	merkleParallelLeaves = 256
+	merkleBatch          = 1024
+)
 
 type (
 	MerkleTree struct {
 		RootNode *MerkleNode
+		leaves   int
 	}
 
 	MerkleNode struct {
@@ -26,54 +47,213 @@ type (
 		Right *MerkleNode
 		Data  []byte
 	}
+
+	// NOTE one step from a leaf up to the root: the hash next to the node reached so far
+	MerkleStep struct {
+		Hash []byte
+		// NOTE the sibling is the left child, so it goes first when hashing
+		Left bool
+	}
+
+	// NOTE sibling path of a leaf, from the leaf up
+	MerkleProof []MerkleStep
+
+	// NOTE MerkleRootBuilder gives the root of NewMerkleTree without building it.
+	// NOTE It keeps one pending subtree per level, inner[i] is set when bit i of
+	// NOTE count is, like carries of a binary counter
+	MerkleRootBuilder struct {
+		inner [][]byte
+		count int
+	}
 )
 
-func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
-	node := MerkleNode{}
+func merkleLeafHash(data []byte) []byte {
+	hash := sha.ComputeHash(append([]byte{merkleLeafPrefix}, data...))
+	return hash[:]
+}
+
+func merkleInnerHash(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, merkleInnerPrefix)
+	data = append(data, left...)
+	data = append(data, right...)
 
+	hash := sha.ComputeHash(data)
+	return hash[:]
+}
+
+func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	if left == nil && right == nil {
-		hash := sha.ComputeHash(data)
-		node.Data = hash[:]
-	} else {
-		prevHashes := append(left.Data, right.Data...)
-		hash := sha.ComputeHash(prevHashes)
-		node.Data = hash[:]
+		return &MerkleNode{Data: merkleLeafHash(data)}
 	}
 
-	node.Left = left
-	node.Right = right
-
-	return &node
+	return &MerkleNode{Left: left, Right: right, Data: merkleInnerHash(left.Data, right.Data)}
 }
 
+// NOTE the tree of no data has the hash of an empty leaf as root
 func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
+	var nodes []*MerkleNode
 
-	// Check the "evenness" of nodes. if not even,
-	// node duplicate
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
+	// NOTE first leafs represent transactions,
+	// NOTE therefore does not contain any children
+	for _, leaf := range data {
+		nodes = append(nodes, NewMerkleNode(nil, nil, leaf))
 	}
-
-	for _, info := range data {
-		// NOTE first leafs represent transactions,
-		// NOTE therefore does not contain any children
-		node := NewMerkleNode(nil, nil, info)
-		nodes = append(nodes, *node)
+	if len(nodes) == 0 {
+		nodes = append(nodes, NewMerkleNode(nil, nil, nil))
 	}
-	// NOTE fill up the tree
-	for i := 0; i < len(data)/2; i++ {
-		var level []MerkleNode
 
+	// NOTE fill up the tree, one level at a time
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
+		level := make([]*MerkleNode, 0, len(nodes)/2)
 		for j := 0; j < len(nodes); j += 2 {
-			// NOTE iteratively create nodes
-			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
-			// add 'em to the tree
-			level = append(level, *node)
+			level = append(level, NewMerkleNode(nodes[j], nodes[j+1], nil))
 		}
 		nodes = level
 	}
 
-	// pass the root the new tree, and return reference 
-	return &MerkleTree{&nodes[0]}
+	return &MerkleTree{RootNode: nodes[0], leaves: len(data)}
+}
+
+// NOTE Proof returns the sibling path of the leaf at `index`, which proves the
+// NOTE leaf is in the tree to anyone who knows the root only
+func (t *MerkleTree) Proof(index int) (MerkleProof, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, fmt.Errorf("leaf %d is out of range, the tree has %d", index, t.leaves)
+	}
+
+	depth := 0
+	for node := t.RootNode; node.Left != nil; node = node.Left {
+		depth++
+	}
+
+	// NOTE all leaves are as deep, bits of the index tell the way down from the root
+	proof := make(MerkleProof, depth)
+	node := t.RootNode
+
+	for level := depth - 1; level >= 0; level-- {
+		if index>>level&1 == 0 {
+			proof[level] = MerkleStep{Hash: node.Right.Data}
+			node = node.Left
+		} else {
+			proof[level] = MerkleStep{Hash: node.Left.Data, Left: true}
+			node = node.Right
+		}
+	}
+
+	return proof, nil
+}
+
+// NOTE VerifyMerkleProof hashes the leaf data up the path, the leaf is in the tree
+// NOTE when that gives the root. Nodes are hashed just like NewMerkleTree does
+func VerifyMerkleProof(leaf []byte, proof MerkleProof, root []byte) bool {
+	node := NewMerkleNode(nil, nil, leaf)
+
+	for _, step := range proof {
+		sibling := &MerkleNode{Data: step.Hash}
+
+		if step.Left {
+			node = NewMerkleNode(sibling, node, nil)
+		} else {
+			node = NewMerkleNode(node, sibling, nil)
+		}
+	}
+
+	return bytes.Equal(node.Data, root)
+}
+
+// NOTE AddLeaf hashes the next leaf and merges the subtrees it completes
+func (m *MerkleRootBuilder) AddLeaf(data []byte) {
+	m.addHash(merkleLeafHash(data))
+}
+
+func (m *MerkleRootBuilder) addHash(hash []byte) {
+	level := 0
+	for ; m.count>>level&1 == 1; level++ {
+		hash = merkleInnerHash(m.inner[level], hash)
+	}
+
+	if level == len(m.inner) {
+		m.inner = append(m.inner, nil)
+	}
+	m.inner[level] = hash
+	m.count++
+}
+
+// NOTE Root closes the pending subtrees from the bottom up. `carry` is the last node
+// NOTE of a level, a level with an odd number of nodes pairs it with itself
+func (m *MerkleRootBuilder) Root() []byte {
+	if m.count == 0 {
+		return merkleLeafHash(nil)
+	}
+
+	var carry []byte
+	for level := 0; ; level++ {
+		pending := m.count>>level&1 == 1
+
+		if carry == nil {
+			if m.count>>level == 1 {
+				return m.inner[level]
+			}
+			if pending {
+				carry = merkleInnerHash(m.inner[level], m.inner[level])
+			}
+			continue
+		}
+
+		if m.count>>level == 0 {
+			return carry
+		}
+		if pending {
+			carry = merkleInnerHash(m.inner[level], carry)
+		} else {
+			carry = merkleInnerHash(carry, carry)
+		}
+	}
+}
+
+// NOTE MerkleRoot gives the root of `count` leaves, `leaf(i)` returning the data of
+// NOTE leaf i. It must be safe to call concurrently, big trees hash their leaves
+// NOTE on every CPU
+func MerkleRoot(count int, leaf func(i int) []byte) []byte {
+	var builder MerkleRootBuilder
+
+	if count < merkleParallelLeaves {
+		for i := 0; i < count; i++ {
+			builder.AddLeaf(leaf(i))
+		}
+		return builder.Root()
+	}
+
+	workers := runtime.NumCPU()
+	hashes := make([][]byte, merkleBatch)
+
+	for start := 0; start < count; start += merkleBatch {
+		end := start + merkleBatch
+		if end > count {
+			end = count
+		}
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := start + w; i < end; i += workers {
+					hashes[i-start] = merkleLeafHash(leaf(i))
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		for _, hash := range hashes[:end-start] {
+			builder.addHash(hash)
+		}
+	}
+
+	return builder.Root()
 }
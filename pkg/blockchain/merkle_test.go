@@ -1,6 +1,7 @@
 package blockchain
 
 import (
+	"blockchain/pkg/sha"
 	"fmt"
 	"testing"
 
@@ -47,3 +48,118 @@ func TestMerkleTree(t *testing.T) {
 
 	assert.Equal(t, root, fmt.Sprintf("%x", tree.RootNode.Data), "Merkle node root has is equal")
 }
+
+func TestMerkleProof(t *testing.T) {
+	data := [][]byte{[]byte("tx1"), []byte("tx2"), []byte("tx3")}
+	tree := NewMerkleTree(data)
+
+	for i, leaf := range data {
+		proof, err := tree.Proof(i)
+		assert.NoError(t, err)
+		assert.True(t, VerifyMerkleProof(leaf, proof, tree.RootNode.Data))
+		assert.False(t, VerifyMerkleProof([]byte("tx4"), proof, tree.RootNode.Data))
+	}
+
+	_, err := tree.Proof(5)
+	assert.Error(t, err)
+}
+
+// NOTE the root by the definition: prefixed hashes, last node duplicated on odd levels
+func referenceMerkleRoot(data [][]byte) []byte {
+	var level [][]byte
+
+	for _, leaf := range data {
+		hash := sha.ComputeHash(append([]byte{0x00}, leaf...))
+		level = append(level, hash[:])
+	}
+
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			hash := sha.ComputeHash(append(append([]byte{0x01}, level[i]...), level[i+1]...))
+			next = append(next, hash[:])
+		}
+		level = next
+	}
+
+	return level[0]
+}
+
+func TestMerkleTreeLeafCounts(t *testing.T) {
+	var data [][]byte
+
+	for count := 1; count <= 1000; count++ {
+		data = append(data, []byte(fmt.Sprintf("tx%d", count)))
+
+		tree := NewMerkleTree(data)
+		if !assert.Equal(t, referenceMerkleRoot(data), tree.RootNode.Data, "%d leaves", count) {
+			return
+		}
+
+		// NOTE every leaf of the small trees, the edges and the middle of the big ones
+		for i := range data {
+			if count > 64 && i > 2 && i < count-3 && i != count/2 {
+				continue
+			}
+
+			proof, err := tree.Proof(i)
+			assert.NoError(t, err)
+			if !assert.True(t, VerifyMerkleProof(data[i], proof, tree.RootNode.Data), "leaf %d of %d", i, count) {
+				return
+			}
+		}
+
+		_, err := tree.Proof(count)
+		assert.Error(t, err)
+	}
+}
+
+// NOTE an inner node fed back as a leaf doesn't give its hash
+func TestMerkleDomainSeparation(t *testing.T) {
+	left, right := NewMerkleNode(nil, nil, []byte("a")), NewMerkleNode(nil, nil, []byte("b"))
+	inner := NewMerkleNode(left, right, nil)
+
+	forged := NewMerkleNode(nil, nil, append(append([]byte{}, left.Data...), right.Data...))
+	assert.NotEqual(t, inner.Data, forged.Data)
+
+	tree := NewMerkleTree([][]byte{[]byte("a"), []byte("b")})
+	assert.False(t, VerifyMerkleProof(append(append([]byte{}, left.Data...), right.Data...), nil, tree.RootNode.Data))
+}
+
+// NOTE the streaming root equals the tree root, serially and over parallel batches
+func TestMerkleRootStreaming(t *testing.T) {
+	counts := []int{merkleBatch - 1, merkleBatch, merkleBatch + 1, 2*merkleBatch + 1, 3*merkleBatch + 7}
+	for count := 0; count <= merkleParallelLeaves+1; count++ {
+		counts = append(counts, count)
+	}
+
+	for _, count := range counts {
+		var data [][]byte
+		for i := 0; i < count; i++ {
+			data = append(data, []byte(fmt.Sprintf("tx%d", i)))
+		}
+
+		root := MerkleRoot(count, func(i int) []byte { return data[i] })
+		assert.Equal(t, NewMerkleTree(data).RootNode.Data, root, "%d leaves", count)
+	}
+}
+
+// NOTE the last leaf repeated gives the same root, blocks holding a transaction twice are refused
+func TestMerkleDuplicateLastLeaf(t *testing.T) {
+	data := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	assert.Equal(t, NewMerkleTree(data).RootNode.Data, NewMerkleTree(append(data, data[2])).RootNode.Data)
+
+	addresses, _ := newTestWallets(t, 1)
+	txs := []*Transaction{CoinbaseTx(addresses[0], "a"), CoinbaseTx(addresses[0], "b"), CoinbaseTx(addresses[0], "c")}
+
+	block := CreateBlock(txs, []byte("parent"), 1)
+	assert.NoError(t, block.Check())
+
+	padded := *block
+	padded.Transactions = append(append([]*Transaction{}, txs...), txs[2])
+	assert.ErrorIs(t, padded.Check(), errBlockDuplicateTx)
+}
@@ -38,7 +38,8 @@ func NewProof(b *Block) *ProfOW {
 }
 
 func (p *ProfOW) InitData(nonce int) []byte {
-	data := bytes.Join([][]byte{p.Block.Hash, p.Block.PrevHash, utils.ToHex(int64(nonce)), utils.ToHex(Diff)}, []byte{})
+	// NOTE the Merkle root, not the hash, which is what comes out of mining
+	data := bytes.Join([][]byte{p.Block.MerkleRoot, p.Block.PrevHash, utils.ToHex(int64(nonce)), utils.ToHex(Diff)}, []byte{})
 
 	return data
 }
@@ -90,5 +91,6 @@ func (pow *ProfOW) Validate() bool {
 	intHash.SetBytes(hash[:])
 
 	// compare the hashes
-	return intHash.Cmp(pow.Target) == -1
+	// NOTE the stored hash must be the mined one, else the header commits to nothing
+	return intHash.Cmp(pow.Target) == -1 && bytes.Equal(hash[:], pow.Block.Hash)
 }
@@ -0,0 +1,242 @@
+// NOTE pruned node - keeps the bodies of the last `Keep` blocks only. Older blocks are
+// NOTE stored header only (no transactions) under the same hash, so walks over the chain
+// NOTE and the height index still work, and their undo data is dropped. Nothing is lost
+// NOTE for validation: their unspent outputs are in the set, which new transactions spend.
+// NOTE A pruned node can't Reindex, nor serve old blocks to others
+
+package blockchain
+
+import (
+	"blockchain/pkg/utils"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+// NOTE blocks which can still be disconnected, or served, on a pruned node
+const MinPruneKeep = 6
+
+var (
+	pruneKey = []byte("prune")
+
+	errPruneKeep = fmt.Errorf("pruned nodes must keep at least %d blocks", MinPruneKeep)
+	errPruned    = errors.New("block bodies are pruned")
+)
+
+type pruneState struct {
+	Keep int
+	// NOTE highest height whose body is gone, -1 before the first prune
+	Height int
+}
+
+// NOTE IsPruned tells a header kept in place of a pruned block
+func (b *Block) IsPruned() bool {
+	return len(b.Transactions) == 0
+}
+
+func (b *Block) header() *Block {
+	header := *b
+	header.Transactions = nil
+
+	return &header
+}
+
+func loadPruneState(txn *badger.Txn) (pruneState, bool, error) {
+	var state pruneState
+
+	item, err := txn.Get(pruneKey)
+	if err == badger.ErrKeyNotFound {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		return gob.NewDecoder(bytes.NewReader(val)).Decode(&state)
+	})
+
+	return state, err == nil, err
+}
+
+func savePruneState(txn kvWriter, state pruneState) error {
+	var buffer bytes.Buffer
+
+	if err := gob.NewEncoder(&buffer).Encode(state); err != nil {
+		return err
+	}
+
+	return txn.Set(pruneKey, buffer.Bytes())
+}
+
+// NOTE SetPruning turns the node into a pruned one keeping the last `keep` blocks,
+// NOTE there is no way back short of downloading the chain again
+func (chain *Blockchain) SetPruning(keep int) error {
+	if keep < MinPruneKeep {
+		return errPruneKeep
+	}
+
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		state, found, err := loadPruneState(txn)
+		if err != nil {
+			return err
+		}
+		if !found {
+			state.Height = -1
+		}
+
+		state.Keep = keep
+
+		return savePruneState(txn, state)
+	})
+}
+
+// NOTE Pruning returns how many blocks the node keeps, false for a full node
+func (chain *Blockchain) Pruning() (int, bool) {
+	var (
+		state  pruneState
+		pruned bool
+	)
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		state, pruned, err = loadPruneState(txn)
+
+		return err
+	})
+	utils.DisplayErr(err)
+
+	return state.Keep, pruned
+}
+
+// NOTE Prune drops bodies and undo data of blocks `Keep` below the last block of the set,
+// NOTE returns how many. Does nothing on a full node
+func (u *UnspentTransactionSET) Prune() (int, error) {
+	var (
+		state     pruneState
+		enabled   bool
+		setHeight int
+	)
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		if state, enabled, err = loadPruneState(txn); err != nil || !enabled {
+			return err
+		}
+
+		commitment, err := loadSetCommitment(txn)
+		if err != nil {
+			return err
+		}
+		setHeight = commitment.state.Height
+
+		return nil
+	})
+	if err != nil || !enabled {
+		return 0, err
+	}
+
+	upTo := setHeight - state.Keep
+	if upTo <= state.Height {
+		return 0, nil
+	}
+
+	batch := u.Blockchain.Database.NewWriteBatch()
+	pruned := 0
+
+	for height := state.Height + 1; height <= upTo; height++ {
+		block, err := u.Blockchain.BlockAtHeight(height)
+		if err != nil {
+			batch.Cancel()
+			return pruned, err
+		}
+
+		if err := batch.Set(block.Hash, block.header().Serialize()); err != nil {
+			batch.Cancel()
+			return pruned, err
+		}
+		if err := batch.Delete(undoKey(block.Hash)); err != nil {
+			batch.Cancel()
+			return pruned, err
+		}
+
+		pruned++
+	}
+
+	state.Height = upTo
+	if err := savePruneState(batch, state); err != nil {
+		batch.Cancel()
+		return 0, err
+	}
+
+	return pruned, batch.Flush()
+}
+
+// NOTE bodies of pruned blocks are gone, what spenders need of their transactions
+// NOTE is still in the set: unspent outputs at their positions, spent ones left empty
+func (chain *Blockchain) transactionFromSet(txID []byte) (Transaction, bool) {
+	var outs []TXO
+
+	prefix := append(append([]byte{}, utxoPrefix...), txID...)
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().KeyCopy(nil)
+
+			// NOTE another txid which starts with this one
+			if len(key) != len(prefix)+4 {
+				continue
+			}
+
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			_, out := parseOutpointKey(key)
+			for len(outs) <= out {
+				outs = append(outs, TXO{})
+			}
+			outs[out] = DeserializeUnspentOutput(v).Output
+		}
+
+		return nil
+	})
+	utils.DisplayErr(err)
+
+	if len(outs) == 0 {
+		return Transaction{}, false
+	}
+
+	return Transaction{ID: txID, Output: outs}, true
+}
+
+// NOTE CatchUp applies the main chain blocks above the last block of the set and prunes,
+// NOTE it is how a pruned node follows the chain, as it can't Reindex
+func (u *UnspentTransactionSET) CatchUp() error {
+	tip, _ := u.Blockchain.GetBestHeightAndLastHash()
+
+	cache, err := u.NewCoinsCache(0)
+	if err != nil {
+		return err
+	}
+
+	if err := u.replayMainChain(cache, cache.height+1, tip); err != nil {
+		cache.Discard()
+		return err
+	}
+
+	if err := cache.Flush(); err != nil {
+		return err
+	}
+
+	_, err = u.Prune()
+
+	return err
+}
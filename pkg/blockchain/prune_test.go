@@ -0,0 +1,43 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrune(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	bobW := wallets[1]
+
+	chain, UTXO := newTestChain(t, "prune", alice)
+	assert.ErrorIs(t, chain.SetPruning(MinPruneKeep-1), errPruneKeep)
+	assert.NoError(t, chain.SetPruning(MinPruneKeep))
+
+	for i := 0; i < 9; i++ {
+		mineTestBlock(chain, UTXO, bob)
+	}
+
+	// NOTE the set is at height 9, bodies up to 9 - 6 are gone
+	for height := 0; height <= 9; height++ {
+		block, err := chain.BlockAtHeight(height)
+		assert.NoError(t, err)
+		assert.Equal(t, height <= 3, block.IsPruned(), "height %d", height)
+
+		err = chain.Database.View(func(txn *badger.Txn) error {
+			_, err := txn.Get(undoKey(block.Hash))
+			return err
+		})
+		assert.Equal(t, height <= 3, err == badger.ErrKeyNotFound, "height %d", height)
+	}
+	assert.Len(t, chain.GetAllHashes(), 10)
+
+	// NOTE the coinbase of block 1 is still spendable
+	before := balance(UTXO, bob)
+	mineTestBlock(chain, UTXO, alice, NewTransaction(bobW, alice, before, UTXO))
+	assert.Zero(t, balance(UTXO, bob))
+
+	assert.Panics(t, UTXO.Reindex)
+}
@@ -0,0 +1,263 @@
+// NOTE partially signed transactions let the node which knows the chain build a transaction,
+// NOTE while the keys stay on another (air-gapped) machine. The container carries the
+// NOTE unsigned transaction plus every output it spends, which is all a signer needs
+// NOTE to compute sighash digests. Flow: create -> sign (one or many parties) -> combine -> finalize
+
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/utils"
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	errPSBTInputs   = errors.New("psbt must carry one previous output per input")
+	errPSBTMismatch = errors.New("psbts describe different transactions")
+	errPSBTUnsigned = errors.New("psbt input is not signed")
+)
+
+type (
+	PSBTInput struct {
+		// NOTE the output this input spends
+		PrevOut TXO
+		// NOTE collected signatures, keyed by hex encoded public key
+		PartialSigs map[string][]byte
+	}
+
+	PartiallySignedTransaction struct {
+		Tx     Transaction
+		Inputs []PSBTInput
+	}
+)
+
+// NOTE NewPSBT wraps an unsigned transaction, prevOuts[i] is spent by tx.Inputs[i]
+func NewPSBT(tx Transaction, prevOuts []TXO) (*PartiallySignedTransaction, error) {
+	if len(prevOuts) != len(tx.Inputs) {
+		return nil, errPSBTInputs
+	}
+
+	unsigned := tx.TrimmedCopy()
+	unsigned.ID = unsigned.Hash()
+
+	psbt := &PartiallySignedTransaction{Tx: unsigned}
+	for _, out := range prevOuts {
+		psbt.Inputs = append(psbt.Inputs, PSBTInput{out, make(map[string][]byte)})
+	}
+
+	return psbt, nil
+}
+
+// NOTE CreatePSBT builds an unsigned payment from `from` to `to`. Only the address is needed,
+// NOTE the private key never touches this machine
+func CreatePSBT(from, to string, amount Amount, UTXO *UnspentTransactionSET) (*PartiallySignedTransaction, error) {
+	var (
+		inputs   []TXI
+		outputs  []TXO
+		prevOuts []TXO
+	)
+
+	pubKeyHash := wallet.AddressPubKeyHash(from)
+	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
+
+	if acc < amount {
+		return nil, ErrInsufficientFunds
+	}
+
+	ids := make(map[string]bool)
+	for txid := range validOutputs {
+		ids[txid] = true
+	}
+	prevTs := UTXO.Blockchain.FindTransactions(ids)
+
+	for txid, outs := range validOutputs {
+		txID, err := hex.DecodeString(txid)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, out := range outs {
+			in := TXI{ID: txID, Out: out}
+
+			prevOut, ok := prevOutput(prevTs, in)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", errPrevTxNotFound, txid)
+			}
+
+			inputs = append(inputs, in)
+			prevOuts = append(prevOuts, prevOut)
+		}
+	}
+
+	outputs = append(outputs, *NewTXO(amount, to))
+
+	if acc > amount {
+		outputs = append(outputs, *NewTXO(acc-amount, from))
+	}
+
+	return NewPSBT(Transaction{ID: nil, Inputs: inputs, Output: outputs}, prevOuts)
+}
+
+// NOTE Sign adds our signature to every input spending an output locked to our key,
+// NOTE returns how many inputs were signed
+func (p *PartiallySignedTransaction) Sign(private ecdsa.PrivateKey, hashType SigHashType) (int, error) {
+	signed := 0
+
+	for inIdx, in := range p.Inputs {
+		// NOTE legacy wallets own outputs locked to their unpadded key, see signingKey
+		pubKey, ok := signingKey(private, in.PrevOut)
+		if !ok || !in.PrevOut.IsLockedWithKey(wallet.PublicKey(pubKey)) {
+			continue
+		}
+
+		signature, err := p.Tx.SignatureFor(private, inIdx, in.PrevOut, hashType)
+		if err != nil {
+			return signed, err
+		}
+
+		if in.PartialSigs == nil {
+			p.Inputs[inIdx].PartialSigs = make(map[string][]byte)
+		}
+		p.Inputs[inIdx].PartialSigs[hex.EncodeToString(pubKey)] = signature
+		signed++
+	}
+
+	return signed, nil
+}
+
+// NOTE CombinePSBT merges signatures collected by different signers of the same transaction
+func CombinePSBT(psbts ...*PartiallySignedTransaction) (*PartiallySignedTransaction, error) {
+	if len(psbts) == 0 {
+		return nil, errPSBTMismatch
+	}
+
+	first := psbts[0]
+	combined, err := NewPSBT(first.Tx, first.prevOuts())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range psbts {
+		if !bytes.Equal(p.Tx.Hash(), combined.Tx.ID) || len(p.Inputs) != len(combined.Inputs) {
+			return nil, errPSBTMismatch
+		}
+
+		// NOTE the ID doesn't cover the outputs spent, a copy claiming others would make
+		// NOTE Finalize check the signatures against the wrong locks
+		for inIdx, in := range p.Inputs {
+			if !sameOutput(in.PrevOut, combined.Inputs[inIdx].PrevOut) {
+				return nil, fmt.Errorf("%w: input %d spends another output", errPSBTMismatch, inIdx)
+			}
+		}
+
+		for inIdx, in := range p.Inputs {
+			for pubKey, signature := range in.PartialSigs {
+				combined.Inputs[inIdx].PartialSigs[pubKey] = signature
+			}
+		}
+	}
+
+	return combined, nil
+}
+
+// NOTE Finalize moves valid signatures into the transaction, which is then ready to be mined
+func (p *PartiallySignedTransaction) Finalize() (*Transaction, error) {
+	tx := p.Tx.TrimmedCopy()
+
+	for inIdx, in := range p.Inputs {
+		finalized := false
+
+		for pubKeyHex, signature := range in.PartialSigs {
+			pubKey, err := hex.DecodeString(pubKeyHex)
+			if err != nil {
+				return nil, err
+			}
+
+			tx.Inputs[inIdx].Signature = signature
+			tx.Inputs[inIdx].PubKey = pubKey
+
+			if tx.VerifyInput(inIdx, in.PrevOut) {
+				finalized = true
+				break
+			}
+		}
+
+		if !finalized {
+			return nil, fmt.Errorf("%w: input %d", errPSBTUnsigned, inIdx)
+		}
+	}
+
+	// NOTE signatures and public keys are not part of the ID, it is the one of the unsigned copy
+	tx.ID = tx.Hash()
+
+	return &tx, nil
+}
+
+// NOTE Fee is what the inputs provide above the outputs, in native coins
+func (p *PartiallySignedTransaction) Fee() (Amount, error) {
+	ins, err := sumByAsset(p.prevOuts())
+	if err != nil {
+		return 0, err
+	}
+
+	outs, err := sumByAsset(p.Tx.Output)
+	if err != nil {
+		return 0, err
+	}
+
+	if in, out := ins[""], outs[""]; out > in {
+		return 0, fmt.Errorf("%w: %s > %s", errValueOut, out, in)
+	}
+
+	return ins[""] - outs[""], nil
+}
+
+// NOTE gob leaves empty fields out, so a decoded copy compares equal to the original
+func sameOutput(a, b TXO) bool {
+	return bytes.Equal(UnspentOutput{Output: a}.Serialize(), UnspentOutput{Output: b}.Serialize())
+}
+
+func (p *PartiallySignedTransaction) prevOuts() []TXO {
+	var outs []TXO
+
+	for _, in := range p.Inputs {
+		outs = append(outs, in.PrevOut)
+	}
+
+	return outs
+}
+
+// NOTE base64 of gob, so the container can be copied around as plain text
+func (p *PartiallySignedTransaction) Encode() string {
+	var buff bytes.Buffer
+
+	err := gob.NewEncoder(&buff).Encode(p)
+	utils.DisplayErr(err)
+
+	return base64.StdEncoding.EncodeToString(buff.Bytes())
+}
+
+func DecodePSBT(encoded string) (*PartiallySignedTransaction, error) {
+	var p PartiallySignedTransaction
+
+	data, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace([]byte(encoded))))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&p); err != nil {
+		return nil, err
+	}
+
+	if len(p.Inputs) != len(p.Tx.Inputs) {
+		return nil, errPSBTInputs
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NOTE two parties sign their own input each, on separate copies
+func TestPSBTCombineFinalize(t *testing.T) {
+	alice, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	bob, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	prevOuts := []TXO{
+		{Value: 3 * Coin, PubkeyHash: wallet.PublicKey(wallet.PublicKeyBytes(alice.PublicKey))},
+		{Value: 2 * Coin, PubkeyHash: wallet.PublicKey(wallet.PublicKeyBytes(bob.PublicKey))},
+	}
+	tx := Transaction{
+		Inputs: []TXI{{ID: []byte("prev-a"), Out: 0}, {ID: []byte("prev-b"), Out: 1}},
+		Output: []TXO{{Value: 5 * Coin, PubkeyHash: []byte("payee")}},
+	}
+
+	psbt, err := NewPSBT(tx, prevOuts)
+	assert.NoError(t, err)
+
+	_, err = NewPSBT(tx, prevOuts[:1])
+	assert.ErrorIs(t, err, errPSBTInputs)
+
+	// NOTE every signer works on a decoded copy, like a file on another machine
+	signed := func(private *ecdsa.PrivateKey) *PartiallySignedTransaction {
+		p, err := DecodePSBT(psbt.Encode())
+		assert.NoError(t, err)
+
+		count, err := p.Sign(*private, SigHashAll)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		return p
+	}
+	aliceSigned, bobSigned := signed(alice), signed(bob)
+
+	_, err = aliceSigned.Finalize()
+	assert.ErrorIs(t, err, errPSBTUnsigned)
+
+	combined, err := CombinePSBT(aliceSigned, bobSigned)
+	assert.NoError(t, err)
+
+	final, err := combined.Finalize()
+	assert.NoError(t, err)
+	for inIdx, prevOut := range prevOuts {
+		assert.True(t, final.VerifyInput(inIdx, prevOut))
+	}
+
+	// NOTE signatures and public keys are not part of the ID, it is known from the start
+	assert.Equal(t, final.Hash(), final.ID)
+	assert.Equal(t, psbt.Tx.ID, final.ID)
+
+	fee, err := psbt.Fee()
+	assert.NoError(t, err)
+	assert.Equal(t, Amount(0), fee)
+
+	// NOTE a copy of another transaction can't be merged in
+	other := *psbt
+	other.Tx = psbt.Tx.TrimmedCopy()
+	other.Tx.Output = []TXO{{Value: 4 * Coin, PubkeyHash: []byte("payee")}}
+	_, err = CombinePSBT(aliceSigned, &other)
+	assert.ErrorIs(t, err, errPSBTMismatch)
+
+	// NOTE ... neither can a copy which claims to spend other outputs
+	claimed, err := DecodePSBT(bobSigned.Encode())
+	assert.NoError(t, err)
+	claimed.Inputs[0].PrevOut.Value = 30 * Coin
+	_, err = CombinePSBT(aliceSigned, claimed)
+	assert.ErrorIs(t, err, errPSBTMismatch)
+
+	fee, err = claimed.Fee()
+	assert.NoError(t, err)
+	assert.Equal(t, 27*Coin, fee)
+}
+
+// NOTE outputs of legacy wallets are locked to the unpadded key, the signer finds that form
+func TestPSBTLegacyKey(t *testing.T) {
+	legacy := shortTestKey(t)
+	if !assert.NotNil(t, legacy) {
+		return
+	}
+	pubKey := wallet.LegacyPublicKeyBytes(legacy.PublicKey)
+
+	tx := Transaction{
+		Inputs: []TXI{{ID: []byte("prev-c"), Out: 0}},
+		Output: []TXO{{Value: Coin, PubkeyHash: []byte("payee")}},
+	}
+	psbt, err := NewPSBT(tx, []TXO{{Value: Coin, PubkeyHash: wallet.PublicKey(pubKey)}})
+	assert.NoError(t, err)
+
+	count, err := psbt.Sign(*legacy, SigHashAll)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	final, err := psbt.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, pubKey, final.Inputs[0].PubKey)
+}
@@ -0,0 +1,369 @@
+// NOTE Reindex walks the chain forward, from genesis to the tip, through a CoinsCache.
+// NOTE The main chain is found by height in the height index:
+// NOTE 	hgt- + big endian uint32 height -> block hash
+// NOTE Every flush of the cache stores the set commitment, whose height is the checkpoint:
+// NOTE a reindex interrupted between flushes (reindexKey still there) goes on from it.
+// NOTE Only an interrupted flush itself makes it start over
+
+package blockchain
+
+import (
+	"blockchain/pkg/utils"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	heightPrefix = []byte("hgt-")
+	// NOTE present while a reindex is not finished
+	reindexKey = []byte("reindex")
+
+	// NOTE how often Progress is called, the last block is always reported
+	progressInterval = time.Second
+
+	errInvalidBlock = errors.New("invalid block")
+)
+
+// NOTE passed to UnspentTransactionSET.Progress during Reindex
+type ReindexProgress struct {
+	Height       int
+	Tip          int
+	BlocksPerSec float64
+	ETA          time.Duration
+}
+
+func heightKey(height int) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte{}, heightPrefix...), uint32(height))
+}
+
+// NOTE indexMainChain puts the block and its ancestors into the height index, until it meets
+// NOTE one which is there already or one which isn't downloaded yet
+func indexMainChain(txn *badger.Txn, block *Block) error {
+	for {
+		if err := txn.Set(heightKey(block.Height), block.Hash); err != nil {
+			return err
+		}
+
+		if len(block.PrevHash) == 0 {
+			return nil
+		}
+
+		item, err := txn.Get(heightKey(block.Height - 1))
+		if err == nil {
+			indexed, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(indexed, block.PrevHash) {
+				return nil
+			}
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		item, err = txn.Get(block.PrevHash)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		block = DeserializeBlock(v)
+	}
+}
+
+// NOTE a block which arrives below the tip is on the main chain when the block above links to it
+func extendsMainChainDown(txn *badger.Txn, block *Block) (bool, error) {
+	item, err := txn.Get(heightKey(block.Height + 1))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	childHash, err := item.ValueCopy(nil)
+	if err != nil {
+		return false, err
+	}
+
+	item, err = txn.Get(childHash)
+	if err != nil {
+		return false, err
+	}
+
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(DeserializeBlock(v).PrevHash, block.Hash), nil
+}
+
+// NOTE ReindexHeights rebuilds the height index walking back from the tip,
+// NOTE for databases written before it existed
+func (chain *Blockchain) ReindexHeights() {
+	u := UnspentTransactionSET{Blockchain: chain}
+	u.DeleteUnspent(heightPrefix)
+
+	batch := chain.Database.NewWriteBatch()
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		if err := batch.Set(heightKey(block.Height), block.Hash); err != nil {
+			batch.Cancel()
+			utils.DisplayErr(err)
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	utils.DisplayErr(batch.Flush())
+}
+
+// NOTE BlockAtHeight returns the main chain block at `height`
+func (chain *Blockchain) BlockAtHeight(height int) (*Block, error) {
+	var block *Block
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(heightKey(height))
+		if err != nil {
+			return fmt.Errorf("height %d: %w", height, err)
+		}
+
+		hash, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		item, err = txn.Get(hash)
+		if err != nil {
+			return fmt.Errorf("block %x at height %d: %w", hash, height, err)
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		block = DeserializeBlock(v)
+		return nil
+	})
+
+	return block, err
+}
+
+// NOTE height to go on from, -1 when the reindex has to start over
+func (u *UnspentTransactionSET) reindexCheckpoint() int {
+	checkpoint := -1
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		if _, err := txn.Get(reindexKey); err != nil {
+			return nil
+		}
+
+		if flushing, err := flushState(txn); err != nil || flushing == flushWriting {
+			return err
+		}
+
+		if _, err := txn.Get(utxoStateKey); err != nil {
+			return nil
+		}
+
+		commitment, err := loadSetCommitment(txn)
+		if err != nil {
+			return err
+		}
+
+		checkpoint = commitment.state.Height + 1
+		return nil
+	})
+	utils.DisplayErr(err)
+
+	return checkpoint
+}
+
+// NOTE Reindex rebuilds the set from the chain, or goes on with a rebuild which was interrupted
+func (u *UnspentTransactionSET) Reindex() {
+	db := u.Blockchain.Database
+
+	if _, pending := u.Blockchain.SnapshotPending(); pending {
+		utils.DisplayErr("history below the snapshot is not downloaded and validated yet")
+	}
+	if _, pruned := u.Blockchain.Pruning(); pruned {
+		utils.DisplayErr(fmt.Errorf("%w, the set can't be rebuilt", errPruned))
+	}
+
+	start := u.reindexCheckpoint()
+
+	if start < 0 {
+		// NOTE the commitment goes first, a set without it is never resumed
+		err := db.Update(func(txn *badger.Txn) error {
+			if err := txn.Set(reindexKey, []byte{1}); err != nil {
+				return err
+			}
+			if err := txn.Delete(utxoFlushKey); err != nil {
+				return err
+			}
+
+			return txn.Delete(utxoStateKey)
+		})
+		utils.DisplayErr(err)
+
+		for _, prefix := range [][]byte{utxoPrefix, undoPrefix, dataPrefix, addrUnspentPrefix, addrTxPrefix, cfilterPrefix, cfheaderPrefix} {
+			u.DeleteUnspent(prefix)
+		}
+
+		start = 0
+	} else {
+		info.Info("Resuming reindex at height %d", start)
+	}
+
+	tip, _ := u.Blockchain.GetBestHeightAndLastHash()
+
+	cache, err := u.NewCoinsCache(0)
+	utils.DisplayErr(err)
+
+	if err := u.replayMainChain(cache, start, tip); err != nil {
+		cache.Discard()
+		utils.DisplayErr(err)
+	}
+
+	utils.DisplayErr(cache.Flush())
+
+	err = db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(reindexKey); err != nil {
+			return err
+		}
+
+		return txn.Set(utxoVersionKey, []byte{utxoVersion})
+	})
+	utils.DisplayErr(err)
+}
+
+// NOTE replay which drops an invalid block together with the blocks above it, they were
+// NOTE stored before the set reached them (see AddBlock). The set stays at its parent,
+// NOTE instead of failing on every start
+func (u *UnspentTransactionSET) replayMainChain(cache *CoinsCache, from, to int) error {
+	err := u.replay(cache, from, to)
+	if !errors.Is(err, errInvalidBlock) {
+		return err
+	}
+
+	info.Info("%s, the chain goes back to height %d", err, cache.height)
+
+	return u.Blockchain.rewind(cache.height, cache.bestBlock)
+}
+
+// NOTE rewind deletes the main chain blocks above `height`, `hash` becomes the tip
+func (chain *Blockchain) rewind(height int, hash []byte) error {
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		for h := height + 1; ; h++ {
+			item, err := txn.Get(heightKey(h))
+			if err == badger.ErrKeyNotFound {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			blockHash, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if err := txn.Delete(blockHash); err != nil {
+				return err
+			}
+			if err := txn.Delete(heightKey(h)); err != nil {
+				return err
+			}
+		}
+
+		if err := txn.Set([]byte("lh"), hash); err != nil {
+			return err
+		}
+		chain.LastHash = hash
+
+		return nil
+	})
+}
+
+// NOTE applies the main chain blocks from..to on top of the cache, which may keep
+// NOTE its set in another database than the one of the blocks (see ValidateSnapshot)
+func (u *UnspentTransactionSET) replay(cache *CoinsCache, from, to int) error {
+	var (
+		started    = time.Now()
+		reported   = started
+		rebuilt    = false
+		prevHash   = cache.bestBlock
+		blockCount = 0
+		lookup     = cache.unspent
+	)
+
+	for height := from; height <= to; height++ {
+		block, err := u.Blockchain.BlockAtHeight(height)
+
+		// NOTE heights of blocks stored before the index existed, or out of order,
+		// NOTE are fixed once and the block is looked up again
+		if (err != nil || !bytes.Equal(block.PrevHash, prevHash)) && !rebuilt {
+			u.Blockchain.ReindexHeights()
+			rebuilt = true
+
+			block, err = u.Blockchain.BlockAtHeight(height)
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(block.PrevHash, prevHash) {
+			return fmt.Errorf("block %x at height %d doesn't extend %x", block.Hash, height, prevHash)
+		}
+
+		// NOTE blocks stored before their parent was connected are verified only here
+		if err := verifyTransactions(block.Transactions, lookup, false); err != nil {
+			return fmt.Errorf("%w %x at height %d: %w", errInvalidBlock, block.Hash, height, err)
+		}
+		if err := cache.Apply(block); errors.Is(err, errSpent) {
+			return fmt.Errorf("%w %x at height %d: %w", errInvalidBlock, block.Hash, height, err)
+		} else if err != nil {
+			return err
+		}
+
+		prevHash = block.Hash
+		blockCount++
+
+		if u.Progress != nil && (time.Since(reported) >= progressInterval || height == to) {
+			reported = time.Now()
+			u.Progress(newReindexProgress(height, to, blockCount, time.Since(started)))
+		}
+	}
+
+	return nil
+}
+
+func newReindexProgress(height, tip, blocks int, elapsed time.Duration) ReindexProgress {
+	progress := ReindexProgress{Height: height, Tip: tip}
+
+	if elapsed > 0 {
+		progress.BlocksPerSec = float64(blocks) / elapsed.Seconds()
+	}
+	if progress.BlocksPerSec > 0 {
+		progress.ETA = time.Duration(float64(tip-height) / progress.BlocksPerSec * float64(time.Second))
+	}
+
+	return progress
+}
@@ -0,0 +1,58 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReindexResumes(t *testing.T) {
+	defer func(budget int) { CoinsCacheBudget = budget }(CoinsCacheBudget)
+	defer func(interval time.Duration) { progressInterval = interval }(progressInterval)
+	progressInterval = 0
+
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW := wallets[0]
+
+	chain, UTXO := newTestChain(t, "reindex", alice)
+	for i := 0; i < 5; i++ {
+		mineTestBlock(chain, UTXO, alice, NewTransaction(aliceW, bob, Coin, UTXO))
+	}
+	expected := UTXO.TxOutSetInfo()
+
+	// NOTE databases of older versions have no height index
+	UTXO.DeleteUnspent(heightPrefix)
+
+	// NOTE a budget this small flushes, and so checkpoints, after every block
+	CoinsCacheBudget = 1
+
+	var reports []ReindexProgress
+	UTXO.Progress = func(p ReindexProgress) {
+		reports = append(reports, p)
+		if p.Height == 3 {
+			panic("interrupted")
+		}
+	}
+	assert.Panics(t, UTXO.Reindex)
+	assert.Equal(t, 3, UTXO.TxOutSetInfo().Height)
+
+	UTXO.Progress = func(p ReindexProgress) { reports = append(reports, p) }
+	assert.True(t, UTXO.Migrate())
+	assert.Equal(t, expected, UTXO.TxOutSetInfo())
+
+	// NOTE 0..3, then 4..5 after the restart
+	assert.Len(t, reports, 6)
+	last := reports[len(reports)-1]
+	assert.Equal(t, 5, last.Height)
+	assert.Equal(t, 5, last.Tip)
+	assert.Zero(t, last.ETA)
+
+	assert.False(t, UTXO.Migrate())
+	assert.Equal(t, 5*Coin, balance(UTXO, bob))
+
+	block, err := chain.BlockAtHeight(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, block.Height)
+}
@@ -0,0 +1,114 @@
+// NOTE the same transaction is verified when it enters the memory pool,
+// NOTE again when a miner picks it up and once more inside MineBlock.
+// NOTE ECDSA is the expensive part, so successful checks are remembered here.
+// NOTE The key is (txid, input index, sighash digest) plus the signature and pubkey:
+// NOTE transaction ID does not cover signatures, so without them a transaction
+// NOTE with a forged signature but the same ID would hit the cache
+
+package blockchain
+
+import (
+	"blockchain/pkg/sha"
+	"bytes"
+	"encoding/binary"
+	"sync"
+)
+
+const DefaultSigCacheSize = 50000
+
+// NOTE shared by the memory pool and block validation of this process
+var SignatureCache = NewSigCache(DefaultSigCacheSize)
+
+type (
+	sigCacheKey [32]byte
+
+	// NOTE bounded set of verified signatures, oldest entries are evicted first
+	SigCache struct {
+		mu      sync.RWMutex
+		entries map[sigCacheKey]struct{}
+		ring    []sigCacheKey
+		next    int
+	}
+)
+
+func NewSigCache(size int) *SigCache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &SigCache{
+		entries: make(map[sigCacheKey]struct{}, size),
+		ring:    make([]sigCacheKey, 0, size),
+	}
+}
+
+func newSigCacheKey(txID []byte, inIdx int, digest, signature, pubKey []byte) sigCacheKey {
+	var buff bytes.Buffer
+
+	// NOTE every part is length prefixed, so different splits can't collide
+	for _, part := range [][]byte{txID, digest, signature, pubKey} {
+		_ = binary.Write(&buff, binary.BigEndian, uint32(len(part)))
+		buff.Write(part)
+	}
+	_ = binary.Write(&buff, binary.BigEndian, int64(inIdx))
+
+	return sigCacheKey(sha.ComputeHash(buff.Bytes()))
+}
+
+func (c *SigCache) contains(key sigCacheKey) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.entries[key]
+
+	return ok
+}
+
+func (c *SigCache) add(key sigCacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+
+	// NOTE ring is full - overwrite the oldest key
+	if len(c.ring) == cap(c.ring) {
+		delete(c.entries, c.ring[c.next])
+		c.ring[c.next] = key
+		c.next = (c.next + 1) % cap(c.ring)
+	} else {
+		c.ring = append(c.ring, key)
+	}
+
+	c.entries[key] = struct{}{}
+}
+
+func (c *SigCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.entries)
+}
+
+// NOTE VerifyInput with the cache in front of ECDSA. `store` is set on memory pool
+// NOTE acceptance only, block validation just reads what the pool already checked.
+// NOTE Spending rules of the output are always evaluated, only ECDSA is cached
+func (c *SigCache) VerifyInput(tx *Transaction, inIdx int, prevOut TXO, store bool) bool {
+	return tx.verifyInput(inIdx, prevOut, func(digest, signature, pubKey []byte) bool {
+		key := newSigCacheKey(tx.ID, inIdx, digest, signature, pubKey)
+		if c.contains(key) {
+			return true
+		}
+
+		if !verifySignature(digest, signature, pubKey) {
+			return false
+		}
+
+		if store {
+			c.add(key)
+		}
+
+		return true
+	})
+}
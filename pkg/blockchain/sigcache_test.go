@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigCacheEviction(t *testing.T) {
+	cache := NewSigCache(3)
+
+	var keys []sigCacheKey
+	for i := 0; i < 5; i++ {
+		keys = append(keys, newSigCacheKey([]byte("tx"), i, []byte("digest"), []byte("sig"), []byte("key")))
+		cache.add(keys[i])
+		cache.add(keys[i])
+	}
+
+	// NOTE the oldest two are gone, the size stays bounded
+	assert.Equal(t, 3, cache.Len())
+	assert.False(t, cache.contains(keys[0]))
+	assert.False(t, cache.contains(keys[1]))
+	for _, key := range keys[2:] {
+		assert.True(t, cache.contains(key))
+	}
+}
+
+func TestSigCacheKey(t *testing.T) {
+	key := newSigCacheKey([]byte("tx"), 0, []byte("digest"), []byte("sig"), []byte("key"))
+
+	assert.Equal(t, key, newSigCacheKey([]byte("tx"), 0, []byte("digest"), []byte("sig"), []byte("key")))
+	assert.NotEqual(t, key, newSigCacheKey([]byte("tx2"), 0, []byte("digest"), []byte("sig"), []byte("key")))
+	assert.NotEqual(t, key, newSigCacheKey([]byte("tx"), 1, []byte("digest"), []byte("sig"), []byte("key")))
+	assert.NotEqual(t, key, newSigCacheKey([]byte("tx"), 0, []byte("digest2"), []byte("sig"), []byte("key")))
+	assert.NotEqual(t, key, newSigCacheKey([]byte("tx"), 0, []byte("digest"), []byte("sig2"), []byte("key")))
+	assert.NotEqual(t, key, newSigCacheKey([]byte("tx"), 0, []byte("digest"), []byte("sig"), []byte("key2")))
+
+	// NOTE length prefixes keep the same bytes split differently apart
+	assert.NotEqual(t, key, newSigCacheKey([]byte("txd"), 0, []byte("igest"), []byte("sig"), []byte("key")))
+}
+
+func TestSigCacheVerifyInput(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	cache := NewSigCache(10)
+	tx, prevOuts := sighashTestTx(*private)
+	tx.ID = []byte("tx")
+	assert.NoError(t, tx.SignInput(*private, 0, prevOuts[0], SigHashAll))
+
+	// NOTE block validation reads the cache only
+	assert.True(t, cache.VerifyInput(tx, 0, prevOuts[0], false))
+	assert.Equal(t, 0, cache.Len())
+
+	assert.True(t, cache.VerifyInput(tx, 0, prevOuts[0], true))
+	assert.Equal(t, 1, cache.Len())
+
+	// NOTE a forged signature under the same ID is no cache hit
+	forged := *tx
+	forged.Inputs = append([]TXI{}, tx.Inputs...)
+	forged.Inputs[0].Signature = append([]byte{}, tx.Inputs[0].Signature...)
+	forged.Inputs[0].Signature[0] ^= 0xff
+	assert.False(t, cache.VerifyInput(&forged, 0, prevOuts[0], true))
+	assert.Equal(t, 1, cache.Len())
+}
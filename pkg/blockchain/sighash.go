@@ -0,0 +1,301 @@
+// NOTE sighash flags decide which parts of a transaction a signature commits to.
+// NOTE ALL is the classic behaviour: every input and every output is signed.
+// NOTE NONE signs only the inputs, so whoever finalizes may pick the outputs.
+// NOTE SINGLE signs only the output with the same index as the signed input.
+// NOTE ANYONECANPAY is a modifier: only the signed input is committed to,
+// NOTE so other parties can keep adding their own inputs afterwards.
+// NOTE the flag byte is appended to the signature, so each input carries its own choice.
+
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"blockchain/pkg/sha"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+type SigHashType byte
+
+const (
+	SigHashAll          SigHashType = 0x01
+	SigHashNone         SigHashType = 0x02
+	SigHashSingle       SigHashType = 0x03
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashBaseMask SigHashType = 0x1f
+
+	// NOTE an unpadded key is shorter only when its coordinates have leading zero bytes,
+	// NOTE more than a few of them don't happen
+	maxLegacyKeyShort = 4
+)
+
+var (
+	errSigHashType   = errors.New("unknown sighash type")
+	errSigHashSingle = errors.New("SIGHASH_SINGLE input has no output with the same index")
+	errInputIndex    = errors.New("input index is out of range")
+	errNotSigner     = errors.New("key can't sign for the spent output")
+)
+
+func (h SigHashType) base() SigHashType {
+	return h & sigHashBaseMask
+}
+
+func (h SigHashType) AnyoneCanPay() bool {
+	return h&SigHashAnyoneCanPay != 0
+}
+
+func (h SigHashType) Valid() bool {
+	if h&^(sigHashBaseMask|SigHashAnyoneCanPay) != 0 {
+		return false
+	}
+
+	base := h.base()
+
+	return base == SigHashAll || base == SigHashNone || base == SigHashSingle
+}
+
+func (h SigHashType) String() string {
+	var name string
+
+	switch h.base() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("UNKNOWN(%#x)", byte(h))
+	}
+
+	if h.AnyoneCanPay() {
+		name += "|ANYONECANPAY"
+	}
+
+	return name
+}
+
+// NOTE ParseSigHashType accepts the names printed by String, e.g. "SINGLE|ANYONECANPAY"
+func ParseSigHashType(s string) (SigHashType, error) {
+	for _, base := range []SigHashType{SigHashAll, SigHashNone, SigHashSingle} {
+		for _, h := range []SigHashType{base, base | SigHashAnyoneCanPay} {
+			if h.String() == s {
+				return h, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", errSigHashType, s)
+}
+
+// NOTE SigHash builds the digest that input `inIdx` signs. prevOut is the output the input spends,
+// NOTE its lock takes the place of the input's pubkey, just like in the original Sign
+func (t *Transaction) SigHash(inIdx int, prevOut TXO, hashType SigHashType) ([]byte, error) {
+	if inIdx < 0 || inIdx >= len(t.Inputs) {
+		return nil, errInputIndex
+	}
+	if !hashType.Valid() {
+		return nil, errSigHashType
+	}
+
+	txCopy := t.TrimmedCopy()
+	txCopy.ID = nil
+	txCopy.Inputs[inIdx].PubKey = prevOut.lockCode()
+
+	switch hashType.base() {
+	case SigHashNone:
+		txCopy.Output = nil
+	case SigHashSingle:
+		if inIdx >= len(txCopy.Output) {
+			return nil, errSigHashSingle
+		}
+
+		// NOTE outputs before ours are blanked, but their positions are kept
+		outputs := make([]TXO, inIdx+1)
+		for i := 0; i < inIdx; i++ {
+			outputs[i] = TXO{Value: -1}
+		}
+		outputs[inIdx] = txCopy.Output[inIdx]
+		txCopy.Output = outputs
+	}
+
+	if hashType.AnyoneCanPay() {
+		txCopy.Inputs = []TXI{txCopy.Inputs[inIdx]}
+	}
+
+	// NOTE the flag itself is committed too, otherwise it could be swapped after signing
+	hash := sha.ComputeHash(append(txCopy.Serialize(), byte(hashType)))
+
+	return hash[:], nil
+}
+
+// NOTE SignatureFor returns a signature without storing it, which lets several keys sign one input
+func (t *Transaction) SignatureFor(private ecdsa.PrivateKey, inIdx int, prevOut TXO, hashType SigHashType) ([]byte, error) {
+	digest, err := t.SigHash(inIdx, prevOut, hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, &private, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE r and s are padded to the curve size, so the halves can always be split back
+	size := curveSize(private.Curve)
+	signature := make([]byte, 2*size+1)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size : 2*size])
+	signature[2*size] = byte(hashType)
+
+	return signature, nil
+}
+
+// NOTE SignInput signs a single input, cooperating parties call it only for the inputs they own
+func (t *Transaction) SignInput(private ecdsa.PrivateKey, inIdx int, prevOut TXO, hashType SigHashType) error {
+	pubKey, ok := signingKey(private, prevOut)
+	if !ok {
+		return fmt.Errorf("%w: input %d", errNotSigner, inIdx)
+	}
+
+	signature, err := t.SignatureFor(private, inIdx, prevOut, hashType)
+	if err != nil {
+		return err
+	}
+
+	t.Inputs[inIdx].Signature = signature
+	t.Inputs[inIdx].PubKey = pubKey
+
+	return nil
+}
+
+// NOTE the form of our key the output is locked to, legacy wallets have unpadded keys
+func signingKey(private ecdsa.PrivateKey, prevOut TXO) ([]byte, bool) {
+	for _, pubKey := range wallet.PublicKeyForms(private.PublicKey) {
+		if prevOut.Channel != nil {
+			if prevOut.Channel.slot(pubKey) >= 0 {
+				return pubKey, true
+			}
+			continue
+		}
+
+		for _, signer := range prevOut.signerHashes() {
+			if bytes.Equal(wallet.PublicKey(pubKey), signer) {
+				return pubKey, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// NOTE pubkey hashes which may sign for the output, channels keep whole keys instead (see slot)
+func (out *TXO) signerHashes() [][]byte {
+	if out.HTLC != nil {
+		return [][]byte{out.HTLC.Recipient, out.HTLC.Refund}
+	}
+
+	return [][]byte{out.PubkeyHash}
+}
+
+// NOTE VerifyInput checks the signature of one input against the output it spends
+func (t *Transaction) VerifyInput(inIdx int, prevOut TXO) bool {
+	return t.verifyInput(inIdx, prevOut, verifySignature)
+}
+
+// NOTE checks a single (digest, signature, pubkey) triple, lets SigCache sit in front of ECDSA
+type sigVerifier func(digest, signature, pubKey []byte) bool
+
+// NOTE every kind of output has its own spending rules, the signature check is shared
+func (t *Transaction) verifyInput(inIdx int, prevOut TXO, verify sigVerifier) bool {
+	if inIdx < 0 || inIdx >= len(t.Inputs) {
+		return false
+	}
+
+	in := t.Inputs[inIdx]
+
+	switch {
+	case prevOut.IsDataCarrier():
+		// NOTE data carriers are provably unspendable
+		return false
+	case prevOut.HTLC != nil:
+		return t.verifyHashTimeLock(inIdx, prevOut, verify)
+	case prevOut.Channel != nil:
+		return t.verifyChannel(inIdx, prevOut, verify)
+	default:
+		// NOTE the key must be the one the output is locked to
+		if !bytes.Equal(wallet.PublicKey(in.PubKey), prevOut.PubkeyHash) {
+			return false
+		}
+
+		return t.checkSignature(inIdx, prevOut, in.Signature, in.PubKey, verify)
+	}
+}
+
+func (t *Transaction) checkSignature(inIdx int, prevOut TXO, signature, pubKey []byte, verify sigVerifier) bool {
+	digest, err := t.SigHash(inIdx, prevOut, SignatureHashType(signature))
+	if err != nil {
+		return false
+	}
+
+	return verify(digest, signature, pubKey)
+}
+
+// NOTE signature is r || s || sighash flag, pubKey is X || Y, both halves padded to the curve size.
+// NOTE Keys of legacy wallets are unpadded and a few bytes short, every split of them is tried
+func verifySignature(digest, signature, pubKey []byte) bool {
+	curve := elliptic.P256()
+	size := curveSize(curve)
+
+	if len(signature) != 2*size+1 || len(pubKey) > 2*size || len(pubKey) < 2*size-maxLegacyKeyShort {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size : 2*size])
+
+	for split := len(pubKey) - size; split <= size; split++ {
+		rawPubKey := ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(pubKey[:split]),
+			Y:     new(big.Int).SetBytes(pubKey[split:]),
+		}
+
+		if ecdsa.Verify(&rawPubKey, digest, r, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NOTE what a signed input commits to in place of its pubkey: the lock of the spent output
+func (out *TXO) lockCode() []byte {
+	if out.HTLC != nil {
+		return out.HTLC.serialize()
+	}
+	if out.Channel != nil {
+		return out.Channel.serialize()
+	}
+
+	return out.PubkeyHash
+}
+
+// NOTE SignatureHashType reads the flag byte stored at the end of a signature
+func SignatureHashType(signature []byte) SigHashType {
+	if len(signature) == 0 {
+		return 0
+	}
+
+	return SigHashType(signature[len(signature)-1])
+}
+
+func curveSize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
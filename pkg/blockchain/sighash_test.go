@@ -0,0 +1,152 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NOTE a transaction spending two outputs of the key into two outputs
+func sighashTestTx(private ecdsa.PrivateKey) (*Transaction, []TXO) {
+	pubKeyHash := wallet.PublicKey(wallet.PublicKeyBytes(private.PublicKey))
+	prevOuts := []TXO{{Value: 3 * Coin, PubkeyHash: pubKeyHash}, {Value: 2 * Coin, PubkeyHash: pubKeyHash}}
+
+	tx := &Transaction{
+		Inputs: []TXI{{ID: []byte("prev-a"), Out: 0}, {ID: []byte("prev-b"), Out: 1}},
+		Output: []TXO{{Value: 4 * Coin, PubkeyHash: []byte("payee")}, {Value: Coin, PubkeyHash: pubKeyHash}},
+	}
+
+	return tx, prevOuts
+}
+
+// NOTE about one key in 128 has a coordinate shorter than the curve size
+func shortTestKey(t *testing.T) *ecdsa.PrivateKey {
+	for i := 0; i < 10000; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+
+		if key.X.BitLen() <= 248 || key.Y.BitLen() <= 248 {
+			return key
+		}
+	}
+
+	return nil
+}
+
+func TestShortPublicKey(t *testing.T) {
+	private := shortTestKey(t)
+	if !assert.NotNil(t, private) {
+		return
+	}
+
+	assert.Len(t, wallet.PublicKeyBytes(private.PublicKey), 64)
+
+	tx, prevOuts := sighashTestTx(*private)
+	for inIdx, prevOut := range prevOuts {
+		assert.NoError(t, tx.SignInput(*private, inIdx, prevOut, SigHashAll))
+		assert.True(t, tx.VerifyInput(inIdx, prevOut))
+	}
+
+	// NOTE the unpadded form is refused
+	tx.Inputs[0].PubKey = append(private.X.Bytes(), private.Y.Bytes()...)
+	assert.Less(t, len(tx.Inputs[0].PubKey), 64)
+	assert.False(t, tx.VerifyInput(0, prevOuts[0]))
+}
+
+func TestSigHashTypes(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	addInput := func(tx *Transaction) { tx.Inputs = append(tx.Inputs, TXI{ID: []byte("prev-c")}) }
+	addOutput := func(tx *Transaction) { tx.Output = append(tx.Output, TXO{Value: Coin, PubkeyHash: []byte("other")}) }
+	changeOutput := func(i int) func(tx *Transaction) {
+		return func(tx *Transaction) { tx.Output[i].Value-- }
+	}
+
+	cases := []struct {
+		name     string
+		hashType SigHashType
+		change   func(tx *Transaction)
+		valid    bool
+	}{
+		{"all, output changed", SigHashAll, changeOutput(0), false},
+		{"all, input added", SigHashAll, addInput, false},
+		{"none, output changed", SigHashNone, changeOutput(0), true},
+		{"none, output added", SigHashNone, addOutput, true},
+		{"none, input added", SigHashNone, addInput, false},
+		{"single, own output changed", SigHashSingle, changeOutput(0), false},
+		{"single, other output changed", SigHashSingle, changeOutput(1), true},
+		{"single, output added", SigHashSingle, addOutput, true},
+		{"anyonecanpay, input added", SigHashAll | SigHashAnyoneCanPay, addInput, true},
+		{"anyonecanpay, output changed", SigHashAll | SigHashAnyoneCanPay, changeOutput(1), false},
+		{"none anyonecanpay, both changed", SigHashNone | SigHashAnyoneCanPay, func(tx *Transaction) { addInput(tx); addOutput(tx) }, true},
+		{"flag swapped", SigHashAll, func(tx *Transaction) { tx.Inputs[0].Signature[len(tx.Inputs[0].Signature)-1] = byte(SigHashNone) }, false},
+	}
+
+	for _, c := range cases {
+		tx, prevOuts := sighashTestTx(*private)
+		assert.NoError(t, tx.SignInput(*private, 0, prevOuts[0], c.hashType), c.name)
+		assert.True(t, tx.VerifyInput(0, prevOuts[0]), c.name)
+
+		c.change(tx)
+		assert.Equal(t, c.valid, tx.VerifyInput(0, prevOuts[0]), c.name)
+	}
+
+	// NOTE SINGLE needs an output at the index of the input
+	tx, prevOuts := sighashTestTx(*private)
+	tx.Output = tx.Output[:1]
+	assert.Error(t, tx.SignInput(*private, 1, prevOuts[1], SigHashSingle))
+}
+
+// NOTE a valid signature by another key doesn't spend the output
+func TestSignatureKeyMismatch(t *testing.T) {
+	owner, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	thief, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tx, prevOuts := sighashTestTx(*owner)
+	assert.ErrorIs(t, tx.SignInput(*thief, 0, prevOuts[0], SigHashAll), errNotSigner)
+
+	// NOTE signed anyway, by hand
+	signature, err := tx.SignatureFor(*thief, 0, prevOuts[0], SigHashAll)
+	assert.NoError(t, err)
+	tx.Inputs[0].Signature, tx.Inputs[0].PubKey = signature, wallet.PublicKeyBytes(thief.PublicKey)
+	assert.False(t, tx.VerifyInput(0, prevOuts[0]))
+
+	assert.NoError(t, tx.SignInput(*owner, 0, prevOuts[0], SigHashAll))
+	assert.True(t, tx.VerifyInput(0, prevOuts[0]))
+}
+
+// NOTE wallets saved before padding keep their short key, and their address hashes it
+func TestLegacyWalletKey(t *testing.T) {
+	addresses, _ := newTestWallets(t, 1)
+	bob := addresses[0]
+
+	private := shortTestKey(t)
+	if !assert.NotNil(t, private) {
+		return
+	}
+	legacy := &wallet.Wallet{PrivateKey: *private, PublicKey: wallet.LegacyPublicKeyBytes(private.PublicKey)}
+	assert.Less(t, len(legacy.PublicKey), 64)
+	assert.Len(t, wallet.PublicKeyForms(private.PublicKey), 2)
+
+	chain, UTXO := newTestChain(t, "legacy", string(legacy.Address()))
+	tx := NewTransaction(legacy, bob, Coin, UTXO)
+	assert.Equal(t, legacy.PublicKey, tx.Inputs[0].PubKey)
+	assert.True(t, chain.VerifyTransaction(tx))
+
+	mineTestBlock(chain, UTXO, bob, tx)
+	assert.Equal(t, Subsidy+Coin, balance(UTXO, bob))
+	assert.Equal(t, Subsidy-Coin, balance(UTXO, string(legacy.Address())))
+
+	// NOTE a key which owns none of the inputs fails instead of leaving them unsigned
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	unsigned := NewTransaction(legacy, bob, Coin, UTXO)
+	assert.Panics(t, func() { chain.SignTransaction(unsigned, *other) })
+}
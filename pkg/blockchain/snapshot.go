@@ -0,0 +1,370 @@
+// NOTE UTXO snapshots - a fresh node starts from the set of another one instead of
+// NOTE replaying every block. The file holds:
+// NOTE 	magic, TxOutSetInfo of the set, the tip block, then every output as snapshotOutput
+// NOTE LoadSnapshot checks the outputs against the hash of the header before the node uses them.
+// NOTE The history below the tip is downloaded afterwards as usual, ValidateSnapshot then
+// NOTE rebuilds the set at the snapshot tip from it and compares the hashes
+
+package blockchain
+
+import (
+	"blockchain/pkg/utils"
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	snapshotMagic = []byte("utxosnap1")
+	// NOTE TxOutSetInfo of a loaded snapshot whose history is not validated yet
+	snapshotKey = []byte("snapshot")
+
+	errSnapshotFile     = errors.New("not a UTXO snapshot file")
+	errSnapshotTip      = errors.New("snapshot tip block doesn't match its header")
+	errSnapshotExpected = errors.New("snapshot is not the one expected")
+	errSnapshotHistory  = errors.New("history doesn't lead to the snapshot")
+	// NOTE the snapshot is wrong, not just incomplete, see ValidateSnapshot
+	ErrSnapshotHash = errors.New("snapshot outputs don't match the set hash")
+)
+
+type snapshotOutput struct {
+	TxID    []byte
+	Out     int
+	Unspent UnspentOutput
+}
+
+// NOTE DumpSnapshot writes the set at its last applied block, from a single read transaction
+func (u UnspentTransactionSET) DumpSnapshot(w io.Writer) (TxOutSetInfo, error) {
+	var header TxOutSetInfo
+
+	buffered := bufio.NewWriter(w)
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		commitment, err := loadSetCommitment(txn)
+		if err != nil {
+			return err
+		}
+
+		header = TxOutSetInfo{
+			Height:    commitment.state.Height,
+			BestBlock: commitment.state.BestBlock,
+			Outputs:   commitment.state.Outputs,
+			Total:     commitment.state.Total,
+			Hash:      commitment.hash.Digest(),
+		}
+
+		item, err := txn.Get(header.BestBlock)
+		if err != nil {
+			return fmt.Errorf("tip %x: %w", header.BestBlock, err)
+		}
+
+		tip, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		if _, err := buffered.Write(snapshotMagic); err != nil {
+			return err
+		}
+
+		enc := gob.NewEncoder(buffered)
+		if err := enc.Encode(header); err != nil {
+			return err
+		}
+		if err := enc.Encode(tip); err != nil {
+			return err
+		}
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		written := 0
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			txID, out := parseOutpointKey(it.Item().KeyCopy(nil))
+			if err := enc.Encode(snapshotOutput{TxID: txID, Out: out, Unspent: DeserializeUnspentOutput(v)}); err != nil {
+				return err
+			}
+			written++
+		}
+
+		if written != header.Outputs {
+			return fmt.Errorf("set holds %d outputs, its commitment counts %d", written, header.Outputs)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return TxOutSetInfo{}, err
+	}
+
+	return header, buffered.Flush()
+}
+
+// NOTE LoadSnapshot creates the database of a fresh node from a snapshot. The file comes
+// NOTE from anywhere, `expected` is the set hash the operator got from a source they trust
+// NOTE (dumptxoutset of their own node). Nothing is left behind when the snapshot is broken
+func LoadSnapshot(nodeId string, r io.Reader, expected []byte) (*Blockchain, TxOutSetInfo, error) {
+	path := fmt.Sprintf(dbPath, nodeId)
+
+	if DirExist(path) {
+		info.Info("Blockchain already exists")
+		runtime.Goexit()
+	}
+
+	db, err := openDB(path, badger.DefaultOptions(path))
+	if err != nil {
+		return nil, TxOutSetInfo{}, err
+	}
+
+	chain := &Blockchain{nil, db}
+
+	header, err := chain.loadSnapshot(bufio.NewReader(r), expected)
+	if err != nil {
+		db.Close()
+		os.RemoveAll(path)
+
+		return nil, TxOutSetInfo{}, err
+	}
+
+	return chain, header, nil
+}
+
+func (chain *Blockchain) loadSnapshot(r io.Reader, expected []byte) (TxOutSetInfo, error) {
+	var (
+		header TxOutSetInfo
+		tip    []byte
+	)
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, snapshotMagic) {
+		return header, errSnapshotFile
+	}
+
+	dec := gob.NewDecoder(r)
+	if err := dec.Decode(&header); err != nil {
+		return header, fmt.Errorf("%w: %v", errSnapshotFile, err)
+	}
+	if !bytes.Equal(header.Hash, expected) {
+		return header, fmt.Errorf("%w: file has %x, expected %x", errSnapshotExpected, header.Hash, expected)
+	}
+	if err := dec.Decode(&tip); err != nil {
+		return header, fmt.Errorf("%w: %v", errSnapshotFile, err)
+	}
+
+	block := DeserializeBlock(tip)
+	if !bytes.Equal(block.Hash, header.BestBlock) || block.Height != header.Height {
+		return header, errSnapshotTip
+	}
+	if err := block.Check(); err != nil {
+		return header, fmt.Errorf("%w: %w", errSnapshotTip, err)
+	}
+
+	commitment := newSetCommitment()
+
+	// NOTE badger limits the size of a transaction, outputs go through a WriteBatch
+	batch := chain.Database.NewWriteBatch()
+
+	for written := 0; written < header.Outputs; written++ {
+		var out snapshotOutput
+		if err := dec.Decode(&out); err != nil {
+			batch.Cancel()
+			return header, fmt.Errorf("%w: output %d: %v", errSnapshotFile, written, err)
+		}
+
+		if err := batch.Set(outpointKey(out.TxID, out.Out), out.Unspent.Serialize()); err != nil {
+			batch.Cancel()
+			return header, err
+		}
+		if err := commitment.add(out.TxID, out.Out, out.Unspent); err != nil {
+			batch.Cancel()
+			return header, err
+		}
+	}
+
+	if err := batch.Flush(); err != nil {
+		return header, err
+	}
+
+	if !bytes.Equal(commitment.hash.Digest(), header.Hash) || commitment.state.Total != header.Total {
+		return header, ErrSnapshotHash
+	}
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		if err := commitment.save(txn, header.Height, header.BestBlock); err != nil {
+			return err
+		}
+		if err := txn.Set(snapshotKey, serializeSetInfo(header)); err != nil {
+			return err
+		}
+		if err := txn.Set(block.Hash, tip); err != nil {
+			return err
+		}
+		if err := txn.Set(heightKey(block.Height), block.Hash); err != nil {
+			return err
+		}
+		if err := txn.Set(utxoVersionKey, []byte{utxoVersion}); err != nil {
+			return err
+		}
+		if err := setChainFormat(txn); err != nil {
+			return err
+		}
+
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	if err != nil {
+		return header, err
+	}
+
+	chain.LastHash = block.Hash
+
+	return header, nil
+}
+
+func (chain *Blockchain) storedBlock(hash []byte) (*Block, error) {
+	var block *Block
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(hash)
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			block = DeserializeBlock(val)
+			return nil
+		})
+	})
+
+	return block, err
+}
+
+func serializeSetInfo(header TxOutSetInfo) []byte {
+	var buffer bytes.Buffer
+
+	err := gob.NewEncoder(&buffer).Encode(header)
+	utils.DisplayErr(err)
+
+	return buffer.Bytes()
+}
+
+// NOTE SnapshotPending returns the header of a loaded snapshot until its history is validated
+func (chain *Blockchain) SnapshotPending() (TxOutSetInfo, bool) {
+	var (
+		header  TxOutSetInfo
+		pending bool
+	)
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(snapshotKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		pending = true
+		return item.Value(func(val []byte) error {
+			return gob.NewDecoder(bytes.NewReader(val)).Decode(&header)
+		})
+	})
+	utils.DisplayErr(err)
+
+	return header, pending
+}
+
+// NOTE replayHistory builds the set at `height` from genesis in a scratch database, through
+// NOTE a CoinsCache which flushes within its budget, and returns the set hash. The set of
+// NOTE the node stays untouched
+func (u *UnspentTransactionSET) replayHistory(height int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "snapshot-history-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := openDB(dir, badger.DefaultOptions(dir))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	scratch := UnspentTransactionSET{Blockchain: &Blockchain{Database: db}}
+
+	cache, err := scratch.NewCoinsCache(0)
+	if err != nil {
+		return nil, err
+	}
+	defer cache.Discard()
+
+	if err := u.replay(cache, 0, height); err != nil {
+		return nil, err
+	}
+
+	return cache.commitment.hash.Digest(), nil
+}
+
+// NOTE ValidateSnapshot checks the downloaded history against the loaded snapshot:
+// NOTE every block down to genesis must be there, and the set rebuilt from them at the
+// NOTE snapshot tip must have the snapshot hash. Done once, afterwards the snapshot is
+// NOTE forgotten and the node is like any other
+func (u *UnspentTransactionSET) ValidateSnapshot() error {
+	header, pending := u.Blockchain.SnapshotPending()
+	if !pending {
+		return nil
+	}
+
+	// NOTE its own walk, a missing block is an error here instead of the end of the chain
+	hash := header.BestBlock
+
+	for height := header.Height; ; height-- {
+		block, err := u.Blockchain.storedBlock(hash)
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("%w: block %x at height %d is missing", errSnapshotHistory, hash, height)
+		}
+		if err != nil {
+			return err
+		}
+
+		if block.Height != height {
+			return fmt.Errorf("%w: block %x at height %d, expected %d", errSnapshotHistory, block.Hash, block.Height, height)
+		}
+
+		if len(block.PrevHash) == 0 {
+			if height != 0 {
+				return fmt.Errorf("%w: block %x at height %d has no parent", errSnapshotHistory, block.Hash, height)
+			}
+			break
+		}
+
+		hash = block.PrevHash
+	}
+
+	digest, err := u.replayHistory(header.Height)
+	if errors.Is(err, errInvalidBlock) {
+		return fmt.Errorf("%w: %w", ErrSnapshotHash, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(digest, header.Hash) {
+		return fmt.Errorf("%w: history gives %x, snapshot %x", ErrSnapshotHash, digest, header.Hash)
+	}
+
+	return u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(snapshotKey)
+	})
+}
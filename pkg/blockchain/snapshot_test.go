@@ -0,0 +1,153 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW := wallets[0]
+
+	chain, UTXO := newTestChain(t, "source", alice)
+	mineTestBlock(chain, UTXO, alice, NewTransaction(aliceW, bob, 5*Coin, UTXO))
+	mineTestBlock(chain, UTXO, alice, NewTransaction(aliceW, bob, 7*Coin, UTXO))
+
+	var file bytes.Buffer
+	header, err := UTXO.DumpSnapshot(&file)
+	assert.NoError(t, err)
+	assert.Equal(t, UTXO.TxOutSetInfo(), header)
+
+	// NOTE a damaged snapshot leaves no database behind
+	damaged := append([]byte{}, file.Bytes()...)
+	damaged[len(damaged)-10] ^= 0xff
+	_, _, err = LoadSnapshot("damaged", bytes.NewReader(damaged), header.Hash)
+	assert.Error(t, err)
+	assert.False(t, DirExist(fmt.Sprintf(dbPath, "damaged")))
+
+	// NOTE ... and so does an intact one with another hash than the operator expects
+	_, _, err = LoadSnapshot("unexpected", bytes.NewReader(file.Bytes()), bytes.Repeat([]byte{1}, len(header.Hash)))
+	assert.ErrorIs(t, err, errSnapshotExpected)
+	assert.False(t, DirExist(fmt.Sprintf(dbPath, "unexpected")))
+
+	// NOTE the tip block must be a valid block itself, not just carry the right hash
+	forgedTip := chain.GetBlock(chain.LastHash)
+	forgedTip.Nonce++
+	var forged bytes.Buffer
+	forged.Write(snapshotMagic)
+	enc := gob.NewEncoder(&forged)
+	assert.NoError(t, enc.Encode(header))
+	assert.NoError(t, enc.Encode(forgedTip.Serialize()))
+	_, _, err = LoadSnapshot("forged", &forged, header.Hash)
+	assert.ErrorIs(t, err, errSnapshotTip)
+	assert.ErrorIs(t, err, errHeaderPoW)
+
+	fresh, loaded, err := LoadSnapshot("fresh", &file, header.Hash)
+	assert.NoError(t, err)
+	t.Cleanup(func() { fresh.Database.Close() })
+	freshUTXO := &UnspentTransactionSET{Blockchain: fresh}
+
+	assert.Equal(t, header, loaded)
+	assert.Equal(t, header, freshUTXO.TxOutSetInfo())
+	assert.Equal(t, balance(UTXO, bob), balance(freshUTXO, bob))
+
+	height, lastHash := fresh.GetBestHeightAndLastHash()
+	assert.Equal(t, 2, height)
+	assert.Equal(t, chain.LastHash, lastHash)
+
+	// NOTE history is not there yet
+	_, pending := fresh.SnapshotPending()
+	assert.True(t, pending)
+	assert.Len(t, fresh.GetAllHashes(), 1)
+	assert.ErrorIs(t, freshUTXO.ValidateSnapshot(), errSnapshotHistory)
+
+	// NOTE the node keeps going on top of the snapshot meanwhile
+	mineTestBlock(fresh, freshUTXO, bob)
+
+	for _, hash := range chain.GetAllHashes() {
+		block := chain.GetBlock(hash)
+		fresh.AddBlock(&block)
+	}
+
+	assert.NoError(t, freshUTXO.ValidateSnapshot())
+	_, pending = fresh.SnapshotPending()
+	assert.False(t, pending)
+	assert.Len(t, fresh.GetAllHashes(), 4)
+
+	// NOTE with the snapshot validated a missing block is a broken database, not the end of the chain
+	err = fresh.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(chain.GetAllHashes()[1])
+	})
+	assert.NoError(t, err)
+	assert.Panics(t, func() { fresh.GetAllHashes() })
+}
+
+// NOTE a snapshot which is consistent in itself, but not with the history. Replayed through
+// NOTE a cache which flushes after every block, the set of the node is left as it was
+func TestSnapshotRefutedByHistory(t *testing.T) {
+	defer func(budget int) { CoinsCacheBudget = budget }(CoinsCacheBudget)
+
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+
+	chain, UTXO := newTestChain(t, "honest", alice)
+	mineTestBlock(chain, UTXO, alice, NewTransaction(wallets[0], bob, 5*Coin, UTXO))
+
+	var file bytes.Buffer
+	header, err := UTXO.DumpSnapshot(&file)
+	assert.NoError(t, err)
+
+	// NOTE same outputs, but bob got more
+	commitment := newSetCommitment()
+	var outputs []snapshotOutput
+	UTXO.forEachOutput(func(txID []byte, out int, unspent UnspentOutput) {
+		if unspent.Output.IsLockedWithKey(wallet.AddressPubKeyHash(bob)) {
+			unspent.Output.Value *= 2
+		}
+		outputs = append(outputs, snapshotOutput{txID, out, unspent})
+		assert.NoError(t, commitment.add(txID, out, unspent))
+	})
+	forged := header
+	forged.Hash, forged.Total = commitment.hash.Digest(), commitment.state.Total
+
+	var forgedFile bytes.Buffer
+	forgedFile.Write(snapshotMagic)
+	enc := gob.NewEncoder(&forgedFile)
+	assert.NoError(t, enc.Encode(forged))
+	tip := chain.GetBlock(chain.LastHash)
+	assert.NoError(t, enc.Encode(tip.Serialize()))
+	for _, out := range outputs {
+		assert.NoError(t, enc.Encode(out))
+	}
+
+	// NOTE the operator was given the forged hash
+	fresh, _, err := LoadSnapshot("forgedset", &forgedFile, forged.Hash)
+	assert.NoError(t, err)
+	t.Cleanup(func() { fresh.Database.Close() })
+	freshUTXO := &UnspentTransactionSET{Blockchain: fresh}
+	assert.Equal(t, 10*Coin, balance(freshUTXO, bob))
+
+	for _, hash := range chain.GetAllHashes() {
+		block := chain.GetBlock(hash)
+		assert.NoError(t, fresh.AddBlock(&block))
+	}
+
+	CoinsCacheBudget = 1
+	state := freshUTXO.TxOutSetInfo()
+	assert.ErrorIs(t, freshUTXO.ValidateSnapshot(), ErrSnapshotHash)
+	assert.Equal(t, state, freshUTXO.TxOutSetInfo())
+	_, pending := fresh.SnapshotPending()
+	assert.True(t, pending)
+
+	// NOTE the honest snapshot replays to the same hash
+	digest, err := UTXO.replayHistory(header.Height)
+	assert.NoError(t, err)
+	assert.Equal(t, header.Hash, digest)
+}
@@ -0,0 +1,369 @@
+// NOTE SPV - simplified payment verification (see merkle.go). A light node keeps block
+// NOTE headers only, each checked for proof of work and linked to its parent, and follows
+// NOTE the longest header chain. Full nodes send it TxProofs of transactions touching its
+// NOTE wallet addresses, it keeps those which lead to a header on its chain and computes
+// NOTE balances from them:
+// NOTE 	spvtx- + txid -> serialized TxProof
+// NOTE The first header without a parent is taken as genesis, the node trusts its peer for it
+
+package blockchain
+
+import (
+	"blockchain/pkg/utils"
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+// NOTE most headers a full node sends at once, the light node asks again for more
+const MaxHeadersPerMessage = 2000
+
+var (
+	spvKey      = []byte("spv")
+	spvTxPrefix = []byte("spvtx-")
+
+	errHeaderOrphan  = errors.New("header doesn't extend a known header")
+	errHeaderHeight  = errors.New("header height doesn't follow its parent")
+	errHeaderGenesis = errors.New("header is another genesis")
+	errNotSPV        = errors.New("not a light node database")
+)
+
+// NOTE IsSPVNode tells whether the database of the node is a light node one
+func IsSPVNode(nodeId string) bool {
+	path := fmt.Sprintf(dbPath, nodeId)
+	if !DirExist(path) {
+		return false
+	}
+
+	chain, err := OpenHeaderChain(nodeId)
+	if err != nil {
+		return false
+	}
+	chain.Database.Close()
+
+	return true
+}
+
+// NOTE OpenHeaderChain opens the database of a light node, creating an empty one the first time
+func OpenHeaderChain(nodeId string) (*Blockchain, error) {
+	path := fmt.Sprintf(dbPath, nodeId)
+	fresh := !DirExist(path)
+
+	db, err := openDB(path, badger.DefaultOptions(path))
+	if err != nil {
+		return nil, err
+	}
+
+	chain := &Blockchain{nil, db}
+
+	err = db.Update(func(txn *badger.Txn) error {
+		if fresh {
+			if err := setChainFormat(txn); err != nil {
+				return err
+			}
+			return txn.Set(spvKey, []byte{1})
+		}
+
+		if _, err := txn.Get(spvKey); err != nil {
+			return errNotSPV
+		}
+		if err := checkChainFormat(txn); err != nil {
+			return err
+		}
+
+		item, err := txn.Get([]byte("lh"))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		chain.LastHash, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return chain, nil
+}
+
+// NOTE BestHeight is the height of the tip, -1 for a light node without headers yet
+func (chain *Blockchain) BestHeight() int {
+	height := -1
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		height, err = bestHeight(txn)
+
+		return err
+	})
+	utils.DisplayErr(err)
+
+	return height
+}
+
+func bestHeight(txn *badger.Txn) (int, error) {
+	item, err := txn.Get([]byte("lh"))
+	if err == badger.ErrKeyNotFound {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	lastHash, err := item.ValueCopy(nil)
+	if err != nil {
+		return -1, err
+	}
+
+	item, err = txn.Get(lastHash)
+	if err != nil {
+		return -1, err
+	}
+
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return -1, err
+	}
+
+	return DeserializeBlock(v).Height, nil
+}
+
+// NOTE Headers returns headers of the main chain from `height` up, at most MaxHeadersPerMessage
+func (chain *Blockchain) Headers(height int) ([]*Block, error) {
+	var headers []*Block
+
+	tip, _ := chain.GetBestHeightAndLastHash()
+
+	for ; height <= tip && len(headers) < MaxHeadersPerMessage; height++ {
+		block, err := chain.BlockAtHeight(height)
+		if err != nil {
+			return nil, err
+		}
+
+		headers = append(headers, block.header())
+	}
+
+	return headers, nil
+}
+
+// NOTE AddHeader checks the header and stores it, true when it is the new tip.
+// NOTE Known headers are skipped
+func (chain *Blockchain) AddHeader(header *Block) (bool, error) {
+	header = header.header()
+
+	if !NewProof(header).Validate() {
+		return false, fmt.Errorf("%w: %x", errHeaderPoW, header.Hash)
+	}
+
+	tip := false
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(header.Hash); err == nil {
+			return nil
+		}
+
+		if len(header.PrevHash) == 0 {
+			if header.Height != 0 {
+				return fmt.Errorf("%w: %x at height %d", errHeaderHeight, header.Hash, header.Height)
+			}
+			if _, err := txn.Get(heightKey(0)); err == nil {
+				return fmt.Errorf("%w: %x", errHeaderGenesis, header.Hash)
+			}
+		} else {
+			item, err := txn.Get(header.PrevHash)
+			if err == badger.ErrKeyNotFound {
+				return fmt.Errorf("%w: %x", errHeaderOrphan, header.Hash)
+			}
+			if err != nil {
+				return err
+			}
+
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if DeserializeBlock(v).Height+1 != header.Height {
+				return fmt.Errorf("%w: %x at height %d", errHeaderHeight, header.Hash, header.Height)
+			}
+		}
+
+		if err := txn.Set(header.Hash, header.Serialize()); err != nil {
+			return err
+		}
+
+		// NOTE every block has the same difficulty, the longest chain is the heaviest one
+		best, err := bestHeight(txn)
+		if err != nil {
+			return err
+		}
+
+		if header.Height <= best {
+			return nil
+		}
+
+		tip = true
+		if err := indexMainChain(txn, header); err != nil {
+			return err
+		}
+
+		return txn.Set([]byte("lh"), header.Hash)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if tip {
+		chain.LastHash = header.Hash
+	}
+
+	return tip, nil
+}
+
+// NOTE AddressTxProofs proves every transaction of pubKeyHash on the main chain, for light nodes
+func (u UnspentTransactionSET) AddressTxProofs(pubKeyHash []byte) ([]TxProof, error) {
+	var txs []AddressTransaction
+
+	if u.AddressIndexEnabled() {
+		txs = u.AddressTransactions(pubKeyHash)
+	} else {
+		var err error
+		if txs, err = u.Blockchain.addressTransactions(pubKeyHash); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		proofs []TxProof
+		block  *Block
+	)
+
+	for _, found := range txs {
+		if block == nil || block.Height != found.Height {
+			var err error
+			if block, err = u.Blockchain.BlockAtHeight(found.Height); err != nil {
+				return nil, err
+			}
+			if block.IsPruned() {
+				return nil, fmt.Errorf("%w: block %x at height %d", errPruned, block.Hash, block.Height)
+			}
+		}
+
+		proof, ok, err := blockTxProof(block, found.TxID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("transaction %x is not in block %x", found.TxID, block.Hash)
+		}
+
+		proofs = append(proofs, proof)
+	}
+
+	return proofs, nil
+}
+
+// NOTE AddTxProof keeps the proof when it leads to a header of the main chain
+func (chain *Blockchain) AddTxProof(p TxProof) error {
+	if err := chain.VerifyTxProof(p); err != nil {
+		return err
+	}
+
+	tx := DeserializeTransaction(p.Transaction)
+
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(append(append([]byte{}, spvTxPrefix...), tx.ID...), p.Serialize())
+	})
+}
+
+// NOTE proven transactions whose blocks are still on the main chain, a reorg drops the others
+func (chain *Blockchain) provenTransactions() []TxProof {
+	var proofs []TxProof
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(spvTxPrefix); it.ValidForPrefix(spvTxPrefix); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			proof, err := DeserializeTxProof(v)
+			if err != nil {
+				return err
+			}
+
+			proofs = append(proofs, proof)
+		}
+
+		return nil
+	})
+	utils.DisplayErr(err)
+
+	var onChain []TxProof
+
+	for _, proof := range proofs {
+		block, err := chain.BlockAtHeight(proof.Header.Height)
+		if err == nil && bytes.Equal(block.Hash, proof.Header.Hash) {
+			onChain = append(onChain, proof)
+		}
+	}
+
+	return onChain
+}
+
+// NOTE ProvenOutputs lists outputs of pubKeyHash in proven transactions which no proven
+// NOTE transaction spends. Its spends are proven too, as they touch the same key
+func (chain *Blockchain) ProvenOutputs(pubKeyHash []byte) []SpendableOutput {
+	var (
+		txs   []Transaction
+		spent = make(map[string]bool)
+		outs  []SpendableOutput
+	)
+
+	heights := make(map[string]int)
+
+	for _, proof := range chain.provenTransactions() {
+		tx := DeserializeTransaction(proof.Transaction)
+		txs = append(txs, tx)
+		heights[string(tx.ID)] = proof.Header.Height
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			spent[string(outpointKey(in.ID, in.Out))] = true
+		}
+	}
+
+	for _, tx := range txs {
+		for outIdx, out := range tx.Output {
+			if !out.IsLockedWithKey(pubKeyHash) || spent[string(outpointKey(tx.ID, outIdx))] {
+				continue
+			}
+
+			outs = append(outs, SpendableOutput{TxID: tx.ID, Out: outIdx, Output: out, Height: heights[string(tx.ID)]})
+		}
+	}
+
+	return outs
+}
+
+// NOTE ProvenBalances is Balances of a light node, by asset and "" for the native coin
+func (chain *Blockchain) ProvenBalances(pubKeyHash []byte) (map[string]Amount, error) {
+	var outs []TXO
+
+	for _, out := range chain.ProvenOutputs(pubKeyHash) {
+		outs = append(outs, out.Output)
+	}
+
+	return sumByAsset(outs)
+}
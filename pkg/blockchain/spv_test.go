@@ -0,0 +1,79 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func syncHeaders(t *testing.T, full, light *Blockchain) {
+	headers, err := full.Headers(light.BestHeight() + 1)
+	assert.NoError(t, err)
+
+	for _, header := range headers {
+		tip, err := light.AddHeader(header)
+		assert.NoError(t, err)
+		assert.True(t, tip)
+	}
+}
+
+func TestSPV(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW, bobW := wallets[0], wallets[1]
+	bobHash := wallet.AddressPubKeyHash(bob)
+
+	chain, UTXO := newTestChain(t, "spv_full", alice)
+	mineTestBlock(chain, UTXO, alice, NewTransaction(aliceW, bob, 7*Coin, UTXO))
+	mineTestBlock(chain, UTXO, alice)
+
+	light, err := OpenHeaderChain("spv_light")
+	assert.NoError(t, err)
+	t.Cleanup(func() { light.Database.Close() })
+	assert.Equal(t, -1, light.BestHeight())
+
+	// NOTE proofs need the headers first
+	proofs, err := UTXO.AddressTxProofs(bobHash)
+	assert.NoError(t, err)
+	assert.Len(t, proofs, 1)
+	assert.ErrorIs(t, light.AddTxProof(proofs[0]), errTxProofChain)
+
+	// NOTE headers are checked on the way in
+	headers, err := chain.Headers(0)
+	assert.NoError(t, err)
+	_, err = light.AddHeader(headers[2])
+	assert.ErrorIs(t, err, errHeaderOrphan)
+
+	forged := *headers[0]
+	forged.Nonce++
+	_, err = light.AddHeader(&forged)
+	assert.ErrorIs(t, err, errHeaderPoW)
+
+	syncHeaders(t, chain, light)
+	assert.Equal(t, 2, light.BestHeight())
+	stored := light.GetBlock(headers[1].Hash)
+	assert.True(t, stored.IsPruned())
+
+	for _, proof := range proofs {
+		assert.NoError(t, light.AddTxProof(proof))
+	}
+	balances, err := light.ProvenBalances(bobHash)
+	assert.NoError(t, err)
+	assert.Equal(t, 7*Coin, balances[""])
+
+	// NOTE the spend touches bob too, so its proof takes the coins away
+	mineTestBlock(chain, UTXO, alice, NewTransaction(bobW, alice, 4*Coin, UTXO))
+	syncHeaders(t, chain, light)
+
+	proofs, err = UTXO.AddressTxProofs(bobHash)
+	assert.NoError(t, err)
+	for _, proof := range proofs {
+		assert.NoError(t, light.AddTxProof(proof))
+	}
+
+	balances, err = light.ProvenBalances(bobHash)
+	assert.NoError(t, err)
+	assert.Equal(t, balance(UTXO, bob), balances[""])
+	assert.Equal(t, 3*Coin, balances[""])
+}
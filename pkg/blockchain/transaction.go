@@ -11,12 +11,10 @@ import (
 	"blockchain/pkg/utils"
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
-	"math/big"
 
 	// "crypto/elliptic"
 	// "math/big"
@@ -27,10 +25,18 @@ type Transaction struct {
 	ID     []byte
 	Inputs []TXI
 	Output []TXO
+	// NOTE transaction can't be mined below this height, 0 means right away
+	LockTime int
+	// NOTE set on asset issuance, see asset.go
+	Issue *AssetIssuance
 }
 
-type TXOs struct {
-	Outs []TXO
+// NOTE single payment of a batch transaction
+type Recipient struct {
+	Address string
+	Amount  Amount
+	// NOTE nil pays the native coin
+	Asset []byte
 }
 
 type TXI struct {
@@ -38,12 +44,23 @@ type TXI struct {
 	Out       int
 	Signature []byte
 	PubKey    []byte
+	// NOTE extra spending data, e.g. the secret of a hash time lock.
+	// NOTE Like signatures it is not part of the ID or of sighash digests
+	Witness [][]byte
 }
 
 type TXO struct {
-	Value int
+	Value Amount
 	// allow user to share and receive coins
 	PubkeyHash []byte
+	// NOTE payload of a data carrier output, see datacarrier.go
+	Data []byte
+	// NOTE hash time lock instead of PubkeyHash, see htlc.go
+	HTLC *HashTimeLock
+	// NOTE 2-of-2 payment channel lock instead of PubkeyHash, see channel.go
+	Channel *ChannelLock
+	// NOTE asset ID of the value, nil is the native coin, see asset.go
+	Asset []byte
 }
 
 func (in *TXI) UserKey(pubKeyHash []byte) bool {
@@ -71,10 +88,10 @@ func (out *TXO) IsLockedWithKey(pubKeyHash []byte) bool {
 // NOTE + we compare the "rights" on the transaction,
 // NOTE if both: owner-hash and transaction which was in output
 
-func NewTXO(value int, address string) *TXO {
-	txo := &TXO{value, nil}
+func NewTXO(value Amount, address string) *TXO {
+	txo := &TXO{Value: value}
 
-	txo.IsLockedWithKey([]byte(address))
+	txo.Lock([]byte(address))
 	return txo
 }
 
@@ -91,16 +108,6 @@ func (tx Transaction) Serialize() []byte {
 	return encoded.Bytes()
 }
 
-func (out TXOs) SerializeOuts() []byte {
-	var buffer bytes.Buffer
-
-	enc := gob.NewEncoder(&buffer)
-	err := enc.Encode(out)
-	utils.DisplayErr(err)
-
-	return buffer.Bytes()
-}
-
 func DeserializeTransactions(data []byte, tx interface{}) {
 	var txn = &tx
 
@@ -110,22 +117,36 @@ func DeserializeTransactions(data []byte, tx interface{}) {
 	utils.DisplayErr(err)
 }
 
-func DeserializeOuts(data []byte) TXOs {
-	var out TXOs
+func DeserializeTransaction(data []byte) Transaction {
+	var tx Transaction
 
 	decode := gob.NewDecoder(bytes.NewReader(data))
-	err := decode.Decode(&out)
+	err := decode.Decode(&tx)
 	utils.DisplayErr(err)
 
-	return out
+	return tx
 }
 
 // Yet again we hash transaction
+// NOTE signatures are left out of the ID, so an input can be signed
+// NOTE (or co-signed by another party) without changing the transaction ID
 func (t *Transaction) Hash() []byte {
 	var hash [32]byte
 
 	txCopy := *t
 	txCopy.ID = []byte{}
+	txCopy.Inputs = make([]TXI, len(t.Inputs))
+
+	// NOTE public keys are left out like the signatures, so the ID is known before
+	// NOTE the transaction is signed (see psbt.go). The coinbase keeps its data
+	for i, in := range t.Inputs {
+		in.Signature = nil
+		in.Witness = nil
+		if !t.IsCoinbase() {
+			in.PubKey = nil
+		}
+		txCopy.Inputs[i] = in
+	}
 
 	hash = sha.ComputeHash(txCopy.Serialize())
 
@@ -135,6 +156,12 @@ func (t *Transaction) Hash() []byte {
 
 // NOTE sign and verify transactions. Similar to wallets, dunno yet what our key represents
 func (t *Transaction) Sign(private ecdsa.PrivateKey, prevT map[string]Transaction) {
+	t.SignWithHashType(private, prevT, SigHashAll)
+}
+
+// NOTE only inputs which spend outputs locked to our key are signed,
+// NOTE so each party of a shared transaction signs just its own inputs
+func (t *Transaction) SignWithHashType(private ecdsa.PrivateKey, prevT map[string]Transaction, hashType SigHashType) {
 	if t.IsCoinbase() {
 		return
 	}
@@ -149,24 +176,34 @@ func (t *Transaction) Sign(private ecdsa.PrivateKey, prevT map[string]Transactio
 		}
 	}
 
-	txCopy := t.TrimmedCopy()
+	signed := 0
 
-	// NOTE idea is that we every transactions besides current are empty
-	for inId, in := range txCopy.Inputs {
-		//NOTE convert bytes into string/key
-		prevTx := prevT[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTx.Output[in.Out].PubkeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
+	for inId, in := range t.Inputs {
+		prevOut, ok := prevOutput(prevT, in)
+		if !ok {
+			utils.DisplayErr("ERROR: Previous output is not correct")
+		}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &private, txCopy.ID)
-		utils.DisplayErr(err)
+		if prevOut.Channel != nil {
+			err := t.signChannelInput(private, inId, prevOut, hashType)
+			utils.DisplayErr(err)
+			signed++
+			continue
+		}
 
-		// NOTE we `sign` ID, which is hash
-		signature := append(r.Bytes(), s.Bytes()...)
+		if _, ok := signingKey(private, prevOut); !ok {
+			continue
+		}
 
-		t.Inputs[inId].Signature = signature
+		// NOTE we `sign` the sighash digest, which depends on the chosen flags
+		err := t.SignInput(private, inId, prevOut, hashType)
+		utils.DisplayErr(err)
+		signed++
+	}
+
+	// NOTE a key which owns none of the inputs is a mistake, not a shared transaction
+	if signed == 0 {
+		utils.DisplayErr(fmt.Sprintf("transaction %x: %s", t.ID, errNotSigner))
 	}
 }
 
@@ -176,13 +213,12 @@ func (t *Transaction) TrimmedCopy() Transaction {
 
 	for _, in := range t.Inputs {
 		// NOTE  							clear out keys
-		input = append(input, TXI{in.ID, in.Out, nil, nil})
+		input = append(input, TXI{ID: in.ID, Out: in.Out})
 	}
 
-	for _, out := range t.Output {
-		output = append(output, TXO{out.Value, out.PubkeyHash})
-	}
-	return Transaction{t.ID, input, output}
+	output = append(output, t.Output...)
+
+	return Transaction{ID: t.ID, Inputs: input, Output: output, LockTime: t.LockTime, Issue: t.Issue}
 }
 
 func (t *Transaction) Verify(prevT map[string]Transaction) bool {
@@ -196,35 +232,9 @@ func (t *Transaction) Verify(prevT map[string]Transaction) bool {
 		}
 	}
 
-	txCopy := t.TrimmedCopy()
-	curve := elliptic.P256()
-
 	for inId, in := range t.Inputs {
-		prevTx := prevT[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTx.Output[in.Out].PubkeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{
-			Curve: curve,
-			X:     &x,
-			Y:     &y,
-		}
-		if !ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) {
+		prevOut, ok := prevOutput(prevT, in)
+		if !ok || !t.VerifyInput(inId, prevOut) {
 			return false
 		}
 	}
@@ -232,38 +242,116 @@ func (t *Transaction) Verify(prevT map[string]Transaction) bool {
 	return true
 }
 
-func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UnspentTransactionSET) *Transaction {
-	var inputs []TXI
+// NOTE look up the output an input points to
+func prevOutput(prevT map[string]Transaction, in TXI) (TXO, bool) {
+	prevTx, ok := prevT[hex.EncodeToString(in.ID)]
+	if !ok || in.Out < 0 || in.Out >= len(prevTx.Output) {
+		return TXO{}, false
+	}
+
+	return prevTx.Output[in.Out], true
+}
+
+func NewTransaction(w *wallet.Wallet, to string, amount Amount, UTXO *UnspentTransactionSET) *Transaction {
+	return NewDataTransaction(w, to, amount, nil, UTXO)
+}
+
+// NOTE same as NewTransaction, but anchors `data` in an unspendable output when it is set
+func NewDataTransaction(w *wallet.Wallet, to string, amount Amount, data []byte, UTXO *UnspentTransactionSET) *Transaction {
+	return NewBatchTransaction(w, []Recipient{{Address: to, Amount: amount}}, data, UTXO)
+}
+
+// NOTE NewBatchTransaction pays every recipient from one set of inputs,
+// NOTE whatever is left goes back to the sender in a single change output
+func NewBatchTransaction(w *wallet.Wallet, recipients []Recipient, data []byte, UTXO *UnspentTransactionSET) *Transaction {
 	var outputs []TXO
 
-	pubKeyHash := wallet.PublicKey(w.PublicKey)
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
+	for _, r := range recipients {
+		if r.Amount <= 0 {
+			utils.DisplayErr(fmt.Sprintf("amount for %s must be positive", r.Address))
+		}
 
-	if acc < amount {
-		info.Info("Error: not enough funds")
+		outputs = append(outputs, *NewAssetTXO(r.Amount, r.Asset, r.Address))
 	}
 
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		utils.DisplayErr(err)
+	return buildTransaction(w, outputs, data, nil, UTXO)
+}
+
+// NOTE pick inputs of the wallet which cover `outputs`, add change (and data, when set) and sign.
+// NOTE Every asset of the outputs is funded separately and gets its own change output.
+// NOTE Units of the `issue`d asset are created, so they need no inputs
+func buildTransaction(w *wallet.Wallet, outputs []TXO, data []byte, issue *AssetIssuance, UTXO *UnspentTransactionSET) *Transaction {
+	var inputs []TXI
+
+	required, err := sumByAsset(outputs)
+	utils.DisplayErr(err)
 
-		for _, out := range outs {
-			input := TXI{txID, out, nil, w.PublicKey}
-			inputs = append(inputs, input)
+	if issue != nil {
+		delete(required, hex.EncodeToString(issue.AssetID()))
+
+		if _, ok := required[""]; !ok {
+			required[""] = 0
 		}
 	}
+
+	pubKeyHash := wallet.PublicKey(w.PublicKey)
 	from := fmt.Sprintf("%s", w.Address())
 
-	outputs = append(outputs, *NewTXO(amount, to))
+	for _, key := range sortedAssets(required) {
+		amount := required[key]
+
+		// NOTE issuer has to spend something of its own, any native output will do
+		target := amount
+		if key == "" && issue != nil && target == 0 {
+			target = 1
+		}
+		if target == 0 {
+			continue
+		}
+
+		asset, err := hex.DecodeString(key)
+		utils.DisplayErr(err)
+
+		acc, validOutputs := UTXO.FindSpendableAssetOutputs(pubKeyHash, asset, target)
+
+		if acc < target {
+			utils.DisplayErr(ErrInsufficientFunds)
+		}
 
-	if acc > amount {
-		outputs = append(outputs, *NewTXO(acc-amount, from))
+		for txid, outs := range validOutputs {
+			txID, err := hex.DecodeString(txid)
+			utils.DisplayErr(err)
+
+			for _, out := range outs {
+				input := TXI{ID: txID, Out: out, PubKey: w.PublicKey}
+				inputs = append(inputs, input)
+			}
+		}
+
+		if acc > amount {
+			outputs = append(outputs, *NewAssetTXO(acc-amount, asset, from))
+		}
 	}
 
-	tx := Transaction{nil, inputs, outputs}
+	// NOTE data goes last, so it never shifts indexes of spendable outputs
+	if len(data) > 0 {
+		dataOut, err := NewDataTXO(data)
+		utils.DisplayErr(err)
+
+		outputs = append(outputs, *dataOut)
+	}
+
+	tx := Transaction{ID: nil, Inputs: inputs, Output: outputs, Issue: issue}
 	tx.ID = tx.Hash()
 	UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey)
 
+	// NOTE every input is the wallet's own, an unsigned one would only fail in the pool
+	for inIdx, in := range tx.Inputs {
+		if len(in.Signature) == 0 {
+			utils.DisplayErr(fmt.Sprintf("transaction %x: input %d is not signed", tx.ID, inIdx))
+		}
+	}
+
 	return &tx
 }
 
@@ -281,11 +369,11 @@ func CoinbaseTx(to, data string) *Transaction {
 
 	}
 
-	txin := TXI{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXO(20, to)
+	txin := TXI{ID: []byte{}, Out: -1, PubKey: []byte(data)}
+	txout := NewTXO(Subsidy, to)
 
-	tx := Transaction{nil, []TXI{txin}, []TXO{*txout}}
-	tx.Hash()
+	tx := Transaction{ID: nil, Inputs: []TXI{txin}, Output: []TXO{*txout}}
+	tx.ID = tx.Hash()
 
 	return &tx
 }
@@ -299,13 +387,39 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("       TransactionID: %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       	 %d", input.Out))
 		lines = append(lines, fmt.Sprintf("       Signature:	 %x", input.Signature))
+		lines = append(lines, fmt.Sprintf("       SigHash:   	 %s", SignatureHashType(input.Signature)))
 		lines = append(lines, fmt.Sprintf("       PubKey:    	 %x", input.PubKey))
+		for _, w := range input.Witness {
+			lines = append(lines, fmt.Sprintf("       Witness:   	 %x", w))
+		}
+	}
+
+	if tx.LockTime > 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
+	}
+
+	if tx.Issue != nil {
+		lines = append(lines, fmt.Sprintf("     Issue: %q by %x, asset %x", tx.Issue.Name, tx.Issue.Issuer, tx.Issue.AssetID()))
 	}
 
 	for i, output := range tx.Output {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
-		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
+		lines = append(lines, fmt.Sprintf("       Value:  %s", output.Value))
 		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubkeyHash))
+		if output.IsDataCarrier() {
+			lines = append(lines, fmt.Sprintf("       Data:   %x", output.Data))
+		}
+		if output.HTLC != nil {
+			lines = append(lines, fmt.Sprintf("       HTLC:   hash %x, recipient %x, refund %x after %d",
+				output.HTLC.Hash, output.HTLC.Recipient, output.HTLC.Refund, output.HTLC.Timeout))
+		}
+		if output.IsAsset() {
+			lines = append(lines, fmt.Sprintf("       Asset:  %x", output.Asset))
+		}
+		if output.Channel != nil {
+			lines = append(lines, fmt.Sprintf("       Channel: payer %x, payee %x, refund after %d",
+				output.Channel.Payer, output.Channel.Payee, output.Channel.Timeout))
+		}
 	}
 
 	return strings.Join(lines, "\n")
@@ -0,0 +1,161 @@
+// NOTE rolling commitment to the UTXO set, so two nodes (or one node before and after
+// NOTE Reindex) can compare their sets by a single hash. Every output is one MuHash element:
+// NOTE its key (prefix + txid + vout) followed by the serialized UnspentOutput.
+// NOTE Update inserts created outputs and removes spent ones, Disconnect does the reverse,
+// NOTE all in the same badger transaction as the set itself
+
+package blockchain
+
+import (
+	"blockchain/pkg/muhash"
+	"blockchain/pkg/utils"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+// NOTE outside of utxoPrefix, so DeleteUnspent keeps it
+var utxoStateKey = []byte("utxostate")
+
+type (
+	// NOTE stored next to the set, describes what it holds
+	utxoState struct {
+		MuHash []byte
+		// NOTE last block applied to the set
+		Height    int
+		BestBlock []byte
+		Outputs   int
+		// NOTE native coins only, assets are not comparable with them
+		Total Amount
+	}
+
+	// NOTE summary of the set printed by gettxoutsetinfo
+	TxOutSetInfo struct {
+		Height    int
+		BestBlock []byte
+		Outputs   int
+		Total     Amount
+		Hash      []byte
+	}
+
+	// NOTE utxoState while a block is being applied
+	setCommitment struct {
+		hash  *muhash.MuHash
+		state utxoState
+	}
+)
+
+func setElement(txID []byte, out int, unspent UnspentOutput) []byte {
+	return append(outpointKey(txID, out), unspent.Serialize()...)
+}
+
+func newSetCommitment() *setCommitment {
+	return &setCommitment{hash: muhash.New()}
+}
+
+// NOTE an empty commitment when the set has none yet
+func loadSetCommitment(txn *badger.Txn) (*setCommitment, error) {
+	commitment := newSetCommitment()
+
+	item, err := txn.Get(utxoStateKey)
+	if err == badger.ErrKeyNotFound {
+		return commitment, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&commitment.state); err != nil {
+		return nil, err
+	}
+
+	if err := commitment.hash.UnmarshalBinary(commitment.state.MuHash); err != nil {
+		return nil, err
+	}
+
+	return commitment, nil
+}
+
+func (c *setCommitment) add(txID []byte, out int, unspent UnspentOutput) error {
+	c.hash.Insert(setElement(txID, out, unspent))
+	c.state.Outputs++
+
+	if unspent.Output.IsAsset() {
+		return nil
+	}
+
+	total, err := c.state.Total.Add(unspent.Output.Value)
+	if err != nil {
+		return fmt.Errorf("output %x:%d: %w", txID, out, err)
+	}
+	c.state.Total = total
+
+	return nil
+}
+
+func (c *setCommitment) remove(txID []byte, out int, unspent UnspentOutput) error {
+	c.hash.Remove(setElement(txID, out, unspent))
+	c.state.Outputs--
+
+	if unspent.Output.IsAsset() {
+		return nil
+	}
+
+	total, err := c.state.Total.Sub(unspent.Output.Value)
+	if err != nil {
+		return fmt.Errorf("output %x:%d: %w", txID, out, err)
+	}
+	c.state.Total = total
+
+	return nil
+}
+
+func (c *setCommitment) save(txn kvWriter, height int, bestBlock []byte) error {
+	state, err := c.hash.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	c.state.MuHash = state
+	c.state.Height = height
+	c.state.BestBlock = bestBlock
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(c.state); err != nil {
+		return err
+	}
+
+	return txn.Set(utxoStateKey, buffer.Bytes())
+}
+
+// NOTE TxOutSetInfo reads the commitment, no scan of the set
+func (u UnspentTransactionSET) TxOutSetInfo() TxOutSetInfo {
+	var summary TxOutSetInfo
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		commitment, err := loadSetCommitment(txn)
+		if err != nil {
+			return err
+		}
+
+		summary = TxOutSetInfo{
+			Height:    commitment.state.Height,
+			BestBlock: commitment.state.BestBlock,
+			Outputs:   commitment.state.Outputs,
+			Total:     commitment.state.Total,
+			Hash:      commitment.hash.Digest(),
+		}
+
+		return nil
+	})
+	utils.DisplayErr(err)
+
+	return summary
+}
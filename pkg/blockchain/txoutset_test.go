@@ -0,0 +1,43 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxOutSetInfo(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW := wallets[0]
+
+	chain, UTXO := newTestChain(t, "txoutset", alice)
+
+	genesis := UTXO.TxOutSetInfo()
+	assert.Equal(t, 0, genesis.Height)
+	assert.Equal(t, 1, genesis.Outputs)
+	assert.Equal(t, Subsidy, genesis.Total)
+
+	pay := NewTransaction(aliceW, bob, 5*Coin, UTXO)
+	mineTestBlock(chain, UTXO, alice, pay)
+	before := UTXO.TxOutSetInfo()
+
+	second := NewTransaction(aliceW, bob, 7*Coin, UTXO)
+	block := chain.MineBlock([]*Transaction{CoinbaseTx(alice, ""), second})
+	assert.NoError(t, UTXO.Update(block))
+
+	// NOTE the incremental hash equals the one of a set rebuilt from scratch
+	updated := UTXO.TxOutSetInfo()
+	assert.Equal(t, 2, updated.Height)
+	assert.Equal(t, block.Hash, updated.BestBlock)
+	assert.Equal(t, UTXO.CountUnspentOuts(), updated.Outputs)
+	assert.Equal(t, 3*Subsidy, updated.Total)
+	assert.NotEqual(t, before.Hash, updated.Hash)
+
+	UTXO.Reindex()
+	assert.Equal(t, updated, UTXO.TxOutSetInfo())
+
+	// NOTE Disconnect brings back the hash of the previous tip
+	assert.NoError(t, UTXO.Disconnect(block))
+	assert.Equal(t, before, UTXO.TxOutSetInfo())
+}
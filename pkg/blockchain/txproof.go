@@ -0,0 +1,118 @@
+// NOTE transaction inclusion proofs - the transaction, the header of its block and the
+// NOTE Merkle path from the transaction to the root in the header. The proof of work of
+// NOTE the header covers the root, so nobody needs the block to check the payment: the
+// NOTE path must lead to the root, and the header must be on the chain the checker knows
+
+package blockchain
+
+import (
+	"blockchain/pkg/utils"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+var (
+	errTxProofPath  = errors.New("merkle path doesn't lead to the block root")
+	errHeaderPoW    = errors.New("block header fails its proof of work")
+	errTxProofChain = errors.New("block is not on the main chain")
+)
+
+type TxProof struct {
+	// NOTE serialized, it is the leaf of the tree
+	Transaction []byte
+	Index       int
+	Path        MerkleProof
+	// NOTE block without transactions
+	Header *Block
+}
+
+// NOTE TxProof proves the transaction is in its main chain block
+func (chain *Blockchain) TxProof(txID []byte) (TxProof, error) {
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		if block.IsPruned() {
+			return TxProof{}, fmt.Errorf("%w: transaction %x is not above height %d", errPruned, txID, block.Height)
+		}
+
+		proof, found, err := blockTxProof(block, txID)
+		if found || err != nil {
+			return proof, err
+		}
+
+		if len(block.PrevHash) == 0 {
+			return TxProof{}, fmt.Errorf("transaction %x not found", txID)
+		}
+	}
+}
+
+func blockTxProof(block *Block, txID []byte) (TxProof, bool, error) {
+	var leaves [][]byte
+	index := -1
+
+	for i, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			index = i
+		}
+		leaves = append(leaves, tx.Serialize())
+	}
+
+	if index < 0 {
+		return TxProof{}, false, nil
+	}
+
+	path, err := NewMerkleTree(leaves).Proof(index)
+	if err != nil {
+		return TxProof{}, false, err
+	}
+
+	return TxProof{Transaction: leaves[index], Index: index, Path: path, Header: block.header()}, true, nil
+}
+
+// NOTE Verify checks the proof on its own: the path and the proof of work of the header
+func (p TxProof) Verify() error {
+	if p.Header == nil || !NewProof(p.Header).Validate() {
+		return errHeaderPoW
+	}
+
+	if !VerifyMerkleProof(p.Transaction, p.Path, p.Header.MerkleRoot) {
+		return errTxProofPath
+	}
+
+	return nil
+}
+
+// NOTE VerifyTxProof checks the proof and that its block is on the main chain of the node
+func (chain *Blockchain) VerifyTxProof(p TxProof) error {
+	if err := p.Verify(); err != nil {
+		return err
+	}
+
+	block, err := chain.BlockAtHeight(p.Header.Height)
+	if err != nil || !bytes.Equal(block.Hash, p.Header.Hash) {
+		return fmt.Errorf("%w: block %x at height %d", errTxProofChain, p.Header.Hash, p.Header.Height)
+	}
+
+	return nil
+}
+
+func (p TxProof) Serialize() []byte {
+	var buffer bytes.Buffer
+
+	err := gob.NewEncoder(&buffer).Encode(p)
+	utils.DisplayErr(err)
+
+	return buffer.Bytes()
+}
+
+func DeserializeTxProof(data []byte) (TxProof, error) {
+	var proof TxProof
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&proof)
+
+	return proof, err
+}
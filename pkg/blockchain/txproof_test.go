@@ -0,0 +1,39 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxProof(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW := wallets[0]
+
+	chain, UTXO := newTestChain(t, "txproof", alice)
+	pay := NewTransaction(aliceW, bob, 3*Coin, UTXO)
+	mineTestBlock(chain, UTXO, bob, pay)
+	mineTestBlock(chain, UTXO, bob)
+
+	proof, err := chain.TxProof(pay.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, proof.Header.Height)
+	assert.Equal(t, pay.ID, DeserializeTransaction(proof.Transaction).ID)
+
+	decoded, err := DeserializeTxProof(proof.Serialize())
+	assert.NoError(t, err)
+	assert.NoError(t, chain.VerifyTxProof(decoded))
+
+	// NOTE another transaction doesn't fit the path
+	forged := decoded
+	forged.Transaction = CoinbaseTx(bob, "").Serialize()
+	assert.ErrorIs(t, forged.Verify(), errTxProofPath)
+
+	// NOTE nor does another root, the header no longer matches its proof of work
+	forged = decoded
+	header := *decoded.Header
+	header.MerkleRoot = NewMerkleTree([][]byte{forged.Transaction}).RootNode.Data
+	forged.Header = &header
+	assert.ErrorIs(t, forged.Verify(), errHeaderPoW)
+}
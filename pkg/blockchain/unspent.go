@@ -3,7 +3,11 @@ package blockchain
 import (
 	"blockchain/pkg/utils"
 	"bytes"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/hex"
+	"errors"
+	"fmt"
 
 	"github.com/dgraph-io/badger"
 )
@@ -13,114 +17,284 @@ import (
 var (
 	utxoPrefix   = []byte("utxo-")
 	prefixLength = len(utxoPrefix)
+	// NOTE layout version of the set, outside of utxoPrefix so DeleteUnspent keeps it
+	utxoVersionKey = []byte("utxover")
+	// NOTE outputs spent by a block, keyed by its hash
+	undoPrefix = []byte("undo-")
+
+	errNoUndo = errors.New("no undo data, the block was applied before undo data was kept")
 )
 
+// NOTE 1 - compacted list of outputs per transaction, positions shifted on every spend
+// NOTE 2 - one key per output: prefix + txid + big endian uint32 vout
+// NOTE 3 - same keys, plus the set commitment of txoutset.go
+const utxoVersion = 3
+
 type (
 	// gain access to the database
 	UnspentTransactionSET struct {
 		Blockchain *Blockchain
+		// NOTE coin selection strategy for new transactions, nil means DefaultCoinSelector
+		Selector CoinSelector
+		// NOTE write-back cache for Update, nil writes every block through.
+		// NOTE Readers other than Lookup see the set as of the last Flush
+		Cache *CoinsCache
+		// NOTE called by Reindex about once a second, nil for no reports
+		Progress func(ReindexProgress)
+	}
+
+	// NOTE unspent output together with what validation and coin selection ask about it
+	UnspentOutput struct {
+		Output   TXO
+		Height   int
+		Coinbase bool
+	}
+
+	// NOTE undo record: output a block spent, and what it was
+	SpentOutput struct {
+		TxID    []byte
+		Out     int
+		Unspent UnspentOutput
 	}
 )
 
-func (u *UnspentTransactionSET) Reindex() {
-	db := u.Blockchain.Database
+func outpointKey(txID []byte, out int) []byte {
+	key := make([]byte, 0, prefixLength+len(txID)+4)
+	key = append(key, utxoPrefix...)
+	key = append(key, txID...)
 
-	u.DeleteUnspent(utxoPrefix)
+	return binary.BigEndian.AppendUint32(key, uint32(out))
+}
 
-	// NOTE get all unspent transactions from the particular block
-	UTXO := u.Blockchain.FindUnspentTransactionsOutputs()
+func parseOutpointKey(key []byte) ([]byte, int) {
+	vout := key[len(key)-4:]
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for txId, outs := range UTXO {
-			key, err := hex.DecodeString(txId)
-			utils.DisplayErr(err)
+	return key[prefixLength : len(key)-4], int(binary.BigEndian.Uint32(vout))
+}
 
-			key = append(utxoPrefix, key...)
+func (c UnspentOutput) Serialize() []byte {
+	var buffer bytes.Buffer
 
-			// PUSH it into database
-			err = txn.Set(key, outs.SerializeOuts())
-			utils.DisplayErr(err)
+	err := gob.NewEncoder(&buffer).Encode(c)
+	utils.DisplayErr(err)
+
+	return buffer.Bytes()
+}
+
+func DeserializeUnspentOutput(data []byte) UnspentOutput {
+	var unspent UnspentOutput
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&unspent)
+	utils.DisplayErr(err)
+
+	return unspent
+}
+
+// NOTE Migrate rebuilds a set written in an older layout, or left behind by an
+// NOTE unfinished cache flush, from the chain, and finishes an interrupted reindex.
+// NOTE Returns whether it had to. Cheap when the set is up to date
+func (u *UnspentTransactionSET) Migrate() bool {
+	var (
+		version    = 1
+		flushing   byte
+		reindexing bool
+	)
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		state, err := flushState(txn)
+		if err != nil {
+			return err
 		}
+		flushing = state
 
-		return nil
-	})
+		if _, err := txn.Get(reindexKey); err == nil {
+			reindexing = true
+		}
 
+		item, err := txn.Get(utxoVersionKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			if len(val) == 1 {
+				version = int(val[0])
+			}
+			return nil
+		})
+	})
 	utils.DisplayErr(err)
+
+	if version == utxoVersion && flushing == 0 && !reindexing {
+		return false
+	}
+
+	u.Reindex()
+
+	return true
 }
 
-// Add some transaction into block
-func (u *UnspentTransactionSET) Update(block *Block) {
-	db := u.Blockchain.Database
+// NOTE Update applies a block to the set: straight through to the database, or into
+// NOTE u.Cache when the caller keeps one for a run of blocks and flushes it itself
+func (u *UnspentTransactionSET) Update(block *Block) error {
+	if u.Cache != nil {
+		return u.Cache.Apply(block)
+	}
+
+	cache, err := u.NewCoinsCache(0)
+	if err != nil {
+		return err
+	}
+
+	if err := cache.Apply(block); err != nil {
+		cache.Discard()
+		return err
+	}
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Inputs {
-					updateOutputs := TXOs{}
-					// NOTE we put prefix on front of outputs of transaction
-					// NOTE then we put
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID) // NOTE get value attached to index
-					utils.DisplayErr(err)
-					var v []byte
-					err = item.Value(func(val []byte) error {
-						v = val
-						return nil
-					})
-
-					utils.DisplayErr(err)
-					// deserialize bytes into TXOs
-					outs := DeserializeOuts(v)
-
-					// TODO take all the outputs which are not attached to the input we are iterating through
-					for outIdx, out := range outs.Outs {
-						if outIdx != in.Out {
-							// NOTE Inputs contain the reference to Output value that created an input
-							// NOTE Also input contains an INDEX to old transaction
-							// NOTE Unspent output is not attached to the current input, but spent one does
-							updateOutputs.Outs = append(updateOutputs.Outs, out)
-						}
+	if err := cache.Flush(); err != nil {
+		return err
+	}
+
+	// NOTE a pruned node drops the block which just got `Keep` deep
+	_, err = u.Prune()
+
+	return err
+}
+
+// NOTE Disconnect reverts Update of the block, which must be the last one applied:
+// NOTE its outputs leave the set and the outputs it spent come back from the undo data
+func (u *UnspentTransactionSET) Disconnect(block *Block) error {
+	if u.Cache != nil {
+		if err := u.Cache.Flush(); err != nil {
+			return err
+		}
+	}
+
+	indexed := u.AddressIndexEnabled()
+
+	return u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(undoKey(block.Hash))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("%w: block %x", errNoUndo, block.Hash)
+		}
+		if err != nil {
+			return err
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		commitment, err := loadSetCommitment(txn)
+		if err != nil {
+			return err
+		}
+
+		created := make(map[string]bool)
+
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			tx := block.Transactions[i]
+			created[hex.EncodeToString(tx.ID)] = true
+
+			for outIdx, out := range tx.Output {
+				if out.IsDataCarrier() {
+					if err := txn.Delete(dataCarrierKey(out.Data, tx.ID, outIdx)); err != nil {
+						return err
 					}
+					continue
+				}
+
+				if err := txn.Delete(outpointKey(tx.ID, outIdx)); err != nil {
+					return err
+				}
+
+				unspent := UnspentOutput{Output: out, Height: block.Height, Coinbase: tx.IsCoinbase()}
+				if err := commitment.remove(tx.ID, outIdx, unspent); err != nil {
+					return err
+				}
 
-					if len(updateOutputs.Outs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							errMsg.Error(err)
-						}
-					} else {
-						// NOTE convert transactions into bytes
-						if err := txn.Set(inID, updateOutputs.SerializeOuts()); err != nil {
-							errMsg.Error(err)
-						}
+				if indexed {
+					if err := unindexUnspent(txn, tx.ID, outIdx, unspent); err != nil {
+						return err
 					}
 				}
 			}
 
-			newOutputs := TXOs{}
-			newOutputs.Outs = append(newOutputs.Outs, tx.Output...)
+			if indexed {
+				if err := unindexTransaction(txn, tx, block.Height); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, spent := range deserializeUndo(v) {
+			// NOTE outputs created and spent inside the block are gone together with it
+			if created[hex.EncodeToString(spent.TxID)] {
+				continue
+			}
+
+			if err := txn.Set(outpointKey(spent.TxID, spent.Out), spent.Unspent.Serialize()); err != nil {
+				return err
+			}
 
-			txID := append(utxoPrefix, tx.ID...)
+			if err := commitment.add(spent.TxID, spent.Out, spent.Unspent); err != nil {
+				return err
+			}
 
-			if err := txn.Set(txID, newOutputs.SerializeOuts()); err != nil {
-				errMsg.Error(err)
+			if indexed {
+				if err := indexUnspent(txn, spent.TxID, spent.Out, spent.Unspent); err != nil {
+					return err
+				}
 			}
 		}
 
-		return nil
+		if err := commitment.save(txn, block.Height-1, block.PrevHash); err != nil {
+			return err
+		}
+
+		if err := txn.Delete(cfilterKey(block.Hash)); err != nil {
+			return err
+		}
+		if err := txn.Delete(cfheaderKey(block.Hash)); err != nil {
+			return err
+		}
+
+		return txn.Delete(undoKey(block.Hash))
 	})
+}
+
+func undoKey(blockHash []byte) []byte {
+	return append(append([]byte{}, undoPrefix...), blockHash...)
+}
+
+func serializeUndo(undo []SpentOutput) []byte {
+	var buffer bytes.Buffer
 
+	err := gob.NewEncoder(&buffer).Encode(undo)
 	utils.DisplayErr(err)
+
+	return buffer.Bytes()
 }
 
-func (u UnspentTransactionSET) FindUnspentTransactions(pubHash []byte) []TXO {
-	var UTXOs []TXO
+func deserializeUndo(data []byte) []SpentOutput {
+	var undo []SpentOutput
 
-	db := u.Blockchain.Database
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo)
+	utils.DisplayErr(err)
 
-	err := db.View(func(txn *badger.Txn) error {
+	return undo
+}
+
+// NOTE forEachOutput calls fn for every unspent output in the set
+func (u UnspentTransactionSET) forEachOutput(fn func(txID []byte, out int, unspent UnspentOutput)) {
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 
 		it := txn.NewIterator(opts)
-
 		defer it.Close()
 
 		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
@@ -132,22 +306,60 @@ func (u UnspentTransactionSET) FindUnspentTransactions(pubHash []byte) []TXO {
 			})
 			utils.DisplayErr(err)
 
-			outs := DeserializeOuts(v)
-
-			for _, out := range outs.Outs {
-				if out.IsLockedWithKey(pubHash) {
-					UTXOs = append(UTXOs, out)
-				}
-			}
+			txID, out := parseOutpointKey(item.KeyCopy(nil))
+			fn(txID, out, DeserializeUnspentOutput(v))
 		}
 		return nil
 	})
 	utils.DisplayErr(err)
+}
+
+// NOTE Lookup finds a single output, false when it is spent or never existed
+func (u UnspentTransactionSET) Lookup(txID []byte, out int) (UnspentOutput, bool) {
+	var (
+		unspent UnspentOutput
+		found   bool
+	)
+
+	if u.Cache != nil {
+		if cached, unspent, known := u.Cache.lookup(txID, out); known {
+			return cached, unspent
+		}
+	}
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(outpointKey(txID, out))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 
-	return nil
+		found = true
+		return item.Value(func(val []byte) error {
+			unspent = DeserializeUnspentOutput(val)
+			return nil
+		})
+	})
+	utils.DisplayErr(err)
+
+	return unspent, found
+}
+
+func (u UnspentTransactionSET) FindUnspentTransactions(pubHash []byte) []TXO {
+	var UTXOs []TXO
+
+	u.forEachOutput(func(_ []byte, _ int, unspent UnspentOutput) {
+		if unspent.Output.IsLockedWithKey(pubHash) {
+			UTXOs = append(UTXOs, unspent.Output)
+		}
+	})
+
+	return UTXOs
 }
 
-// TODO count how many unspent outputs are there in block
+// NOTE count how many unspent outputs are there in the set
 func (u *UnspentTransactionSET) CountUnspentOuts() int {
 	db := u.Blockchain.Database
 	counter := 0
@@ -175,104 +387,94 @@ func (u *UnspentTransactionSET) CountUnspentOuts() int {
 // TODO Task - iterate over transactions, but
 // run through the database and delete all prefixed keys
 func (u *UnspentTransactionSET) DeleteUnspent(prefix []byte) {
-	// NOTE create a closure with modification function in
-	deleteClosure := func(keyToDelete [][]byte) error {
-		// NOTE enable read/write transaction
-		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
-
-			// iterate over list of keys, and invoke write transaction
-			for _, v := range keyToDelete {
-				if err := txn.Delete(v); err != nil {
-					return err
-				}
-			}
-
-			return nil
-		}); err != nil {
-			return err
-		}
-
-		return nil
-	}
-
-	collectSize := 10000 // optimal amount of keys for deletion per one function call
-
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
+	// NOTE a WriteBatch commits whenever its transaction is full, so any amount of keys
+	// NOTE goes without counting them into batches by hand
+	batch := u.Blockchain.Database.NewWriteBatch()
 
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions // NOTE modifying default query parameters of badger
 		opts.PrefetchValues = false           // NOTE retrieving a key without reeding the data
 
 		it := txn.NewIterator(opts)
-
 		defer it.Close()
 
-		// add capacity of length of utx prefix in bytes
-		keyForDelete := make([][]byte, 0, collectSize)
-		keyCollectedForDelete := 0
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			// NOTE look up for those which we want to delete, and collect 'em into slice
-			key := it.Item().KeyCopy(nil)
-			keyForDelete = append(keyForDelete, key)
-
-			// NOTE indicate that the quantity to delete
-			keyCollectedForDelete++
-
-			// delete each key with function utility-closure
-			// by remembering lexical environment we do not
-			// iterate from the start each time
-			if keyCollectedForDelete == collectSize {
-				if err := deleteClosure(keyForDelete); err != nil {
-					errMsg.Error(err)
-				}
-				keyForDelete = make([][]byte, 0, collectSize)
-			}
-		}
-
-		// if we have left keys for delete - delete
-		if keyCollectedForDelete > 0 {
-			if err := deleteClosure(keyForDelete); err != nil {
-				errMsg.Error(err)
+			if err := batch.Delete(it.Item().KeyCopy(nil)); err != nil {
+				return err
 			}
 		}
 
 		return nil
 	})
+	if err != nil {
+		batch.Cancel()
+		errMsg.Error(err)
+	}
 
+	if err := batch.Flush(); err != nil {
+		errMsg.Error(err)
+	}
 }
 
-func (u UnspentTransactionSET) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOuts := make(map[string][]int)
-	accumulated := 0
-	db := u.Blockchain.Database
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+// NOTE SpendableOutputs lists every unspent output locked to pubKeyHash, assets included
+func (u UnspentTransactionSET) SpendableOutputs(pubKeyHash []byte) []SpendableOutput {
+	if u.AddressIndexEnabled() {
+		return u.AddressUnspent(pubKeyHash)
+	}
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
+	var spendable []SpendableOutput
 
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			var v []byte
-			err := item.Value(func(val []byte) error {
-				v = val
-				return nil
-			})
-			utils.DisplayErr(err)
-			k = bytes.TrimPrefix(k, utxoPrefix)
-			txID := hex.EncodeToString(k)
-			outs := DeserializeOuts(v)
-
-			for outIdx, out := range outs.Outs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
-				}
-			}
+	u.forEachOutput(func(txID []byte, out int, unspent UnspentOutput) {
+		if unspent.Output.IsLockedWithKey(pubKeyHash) {
+			spendable = append(spendable, SpendableOutput{TxID: txID, Out: out, Output: unspent.Output, Height: unspent.Height})
 		}
-		return nil
 	})
-	utils.DisplayErr(err)
+
+	return spendable
+}
+
+// NOTE SelectOutputs picks native outputs worth at least `amount` with the given strategy
+func (u UnspentTransactionSET) SelectOutputs(pubKeyHash []byte, amount Amount, selector CoinSelector) (Amount, map[string][]int, error) {
+	return u.SelectAssetOutputs(pubKeyHash, nil, amount, selector)
+}
+
+// NOTE SelectAssetOutputs is SelectOutputs over the outputs of one asset, nil is the native coin
+func (u UnspentTransactionSET) SelectAssetOutputs(pubKeyHash, asset []byte, amount Amount, selector CoinSelector) (Amount, map[string][]int, error) {
+	if selector == nil {
+		selector = DefaultCoinSelector
+	}
+
+	var candidates []SpendableOutput
+	for _, out := range u.SpendableOutputs(pubKeyHash) {
+		if bytes.Equal(out.Output.Asset, asset) {
+			candidates = append(candidates, out)
+		}
+	}
+
+	selected, err := selector.Select(candidates, amount)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	unspentOuts := make(map[string][]int)
+	for _, out := range selected {
+		txID := hex.EncodeToString(out.TxID)
+		unspentOuts[txID] = append(unspentOuts[txID], out.Out)
+	}
+
+	return sumOutputs(selected), unspentOuts, nil
+}
+
+// NOTE uses the strategy of the set, callers compare the result with amount to detect missing funds
+func (u UnspentTransactionSET) FindSpendableOutputs(pubKeyHash []byte, amount Amount) (Amount, map[string][]int) {
+	return u.FindSpendableAssetOutputs(pubKeyHash, nil, amount)
+}
+
+func (u UnspentTransactionSET) FindSpendableAssetOutputs(pubKeyHash, asset []byte, amount Amount) (Amount, map[string][]int) {
+	accumulated, unspentOuts, err := u.SelectAssetOutputs(pubKeyHash, asset, amount, u.Selector)
+	if err != nil {
+		return 0, make(map[string][]int)
+	}
+
 	return accumulated, unspentOuts
 }
@@ -0,0 +1,153 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnspentKeepsOutputIndexes(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW, bobW := wallets[0], wallets[1]
+
+	chain, UTXO := newTestChain(t, "outpoints", alice)
+
+	// NOTE outputs: 0 - bob, 1 - alice's change
+	pay := NewTransaction(aliceW, bob, 5*Coin, UTXO)
+	mineTestBlock(chain, UTXO, alice, pay)
+
+	back := NewTransaction(bobW, alice, 5*Coin, UTXO)
+	mineTestBlock(chain, UTXO, alice, back)
+
+	_, found := UTXO.Lookup(pay.ID, 0)
+	assert.False(t, found)
+
+	change, found := UTXO.Lookup(pay.ID, 1)
+	assert.True(t, found)
+	assert.Equal(t, Subsidy-5*Coin, change.Output.Value)
+	assert.Equal(t, 1, change.Height)
+	assert.False(t, change.Coinbase)
+
+	// NOTE spends pay:1, which a compacted set would have moved to position 0
+	all := NewTransaction(aliceW, bob, 3*Subsidy, UTXO)
+	mineTestBlock(chain, UTXO, alice, all)
+
+	assert.Equal(t, 3*Subsidy, balance(UTXO, bob))
+	assert.Equal(t, Subsidy, balance(UTXO, alice))
+
+	incremental := UTXO.SpendableOutputs(wallet.AddressPubKeyHash(alice))
+	UTXO.Reindex()
+	assert.ElementsMatch(t, incremental, UTXO.SpendableOutputs(wallet.AddressPubKeyHash(alice)))
+}
+
+func TestUnspentMigration(t *testing.T) {
+	addresses, _ := newTestWallets(t, 1)
+	alice := addresses[0]
+
+	chain, UTXO := newTestChain(t, "migration", alice)
+	assert.False(t, UTXO.Migrate())
+
+	// NOTE version 1 layout: no version key, compacted outputs under utxo-<txid>
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(utxoVersionKey); err != nil {
+			return err
+		}
+
+		return txn.Set(append(append([]byte{}, utxoPrefix...), []byte("old-layout-txid")...), []byte("old"))
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, UTXO.Migrate())
+	assert.False(t, UTXO.Migrate())
+	assert.Equal(t, 1, UTXO.CountUnspentOuts())
+	assert.Equal(t, Subsidy, balance(UTXO, alice))
+}
+
+// NOTE stores a block as the main chain tip without any check
+func storeTestBlock(t *testing.T, chain *Blockchain, block *Block) {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+		if err := txn.Set(heightKey(block.Height), block.Hash); err != nil {
+			return err
+		}
+
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	assert.NoError(t, err)
+	chain.LastHash = block.Hash
+}
+
+// NOTE a block spending a spent output leaves the set alone, and one which got stored
+// NOTE anyway is dropped by Reindex instead of making it fail on every start
+func TestUpdateRefusesSpentOutput(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW := wallets[0]
+
+	chain, UTXO := newTestChain(t, "badspend", alice)
+
+	first := NewTransaction(aliceW, bob, Coin, UTXO)
+	second := NewTransaction(aliceW, bob, 2*Coin, UTXO)
+	mineTestBlock(chain, UTXO, alice, first)
+	tip, state := chain.LastHash, UTXO.TxOutSetInfo()
+
+	bad := CreateBlock([]*Transaction{CoinbaseTx(alice, ""), second}, tip, 2)
+	assert.ErrorIs(t, UTXO.Update(bad), errSpent)
+	assert.Equal(t, state, UTXO.TxOutSetInfo())
+	assert.False(t, UTXO.Migrate())
+
+	assert.ErrorIs(t, chain.AddBlock(bad), errMissingOutput)
+	assert.Equal(t, tip, chain.LastHash)
+
+	// NOTE like blocks of a download which arrive from the tip back
+	storeTestBlock(t, chain, bad)
+	storeTestBlock(t, chain, CreateBlock([]*Transaction{CoinbaseTx(bob, "")}, bad.Hash, 3))
+
+	UTXO.Reindex()
+	assert.Equal(t, tip, chain.LastHash)
+	height, lastHash := chain.GetBestHeightAndLastHash()
+	assert.Equal(t, 1, height)
+	assert.Equal(t, tip, lastHash)
+	_, err := chain.BlockAtHeight(2)
+	assert.Error(t, err)
+	assert.Equal(t, state, UTXO.TxOutSetInfo())
+	assert.False(t, UTXO.Migrate())
+}
+
+// NOTE more keys than two of the 10000 key batches it used to delete in
+func TestDeleteUnspentBatches(t *testing.T) {
+	addresses, _ := newTestWallets(t, 1)
+	chain, UTXO := newTestChain(t, "deletebatches", addresses[0])
+
+	prefix := []byte("test-")
+	batch := chain.Database.NewWriteBatch()
+	for i := 0; i < 2*10000+5; i++ {
+		assert.NoError(t, batch.Set(append(append([]byte{}, prefix...), heightKey(i)...), []byte{1}))
+	}
+	assert.NoError(t, batch.Flush())
+	state := UTXO.TxOutSetInfo()
+
+	UTXO.DeleteUnspent(prefix)
+
+	left := 0
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			left++
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, left)
+
+	// NOTE keys of other prefixes stay
+	assert.Equal(t, state, UTXO.TxOutSetInfo())
+	assert.Equal(t, 1, UTXO.CountUnspentOuts())
+}
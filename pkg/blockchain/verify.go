@@ -0,0 +1,240 @@
+// NOTE verifying transaction one by one means one walk over the whole chain per input
+// NOTE (FindTransaction) and one ECDSA check after another. Here every previous output
+// NOTE is looked up in the unspent set, spends within the batch are tracked, and all
+// NOTE inputs of a block are then checked with a bounded pool of workers, the first
+// NOTE invalid input stops the rest
+
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+var (
+	// NOTE amount of goroutines checking signatures at the same time
+	VerifyWorkers = runtime.NumCPU()
+
+	errPrevTxNotFound = errors.New("previous transaction does not exist")
+	errValueOut       = errors.New("outputs spend more than the inputs provide")
+	errMissingOutput  = errors.New("input spends a missing or already spent output")
+	errSetTip         = errors.New("unspent set is not at the parent of the block")
+)
+
+type (
+	inputCheck struct {
+		tx      *Transaction
+		inIdx   int
+		prevOut TXO
+	}
+
+	// NOTE finds an unspent output, false when it is spent or never existed
+	outputLookup func(txID []byte, out int) (UnspentOutput, bool)
+)
+
+// NOTE VerifyBlock checks every transaction of a block against the unspent set,
+// NOTE which must be right at the parent of the block, see VerifyTransactions
+func (chain *Blockchain) VerifyBlock(block *Block) error {
+	u := UnspentTransactionSET{Blockchain: chain}
+
+	if tip := u.TxOutSetInfo().BestBlock; len(tip) == 0 || !bytes.Equal(tip, block.PrevHash) {
+		return fmt.Errorf("%w: set at %x, block %x builds on %x", errSetTip, tip, block.Hash, block.PrevHash)
+	}
+
+	return chain.VerifyTransactions(block.Transactions)
+}
+
+// NOTE VerifyTransactions checks all inputs of the given transactions concurrently.
+// NOTE Transactions may spend outputs created earlier in the same slice, like within one
+// NOTE block, the rest must be in the unspent set. No output can be spent twice
+func (chain *Blockchain) VerifyTransactions(txs []*Transaction) error {
+	return verifyTransactions(txs, UnspentTransactionSET{Blockchain: chain}.Lookup, false)
+}
+
+// NOTE `cache` tells whether successful checks are remembered in SignatureCache,
+// NOTE which is done for transactions accepted into the memory pool
+func verifyTransactions(txs []*Transaction, lookup outputLookup, cache bool) error {
+	checks, err := collectInputChecks(txs, lookup)
+	if err != nil {
+		return err
+	}
+
+	return runInputChecks(checks, VerifyWorkers, cache)
+}
+
+func collectInputChecks(txs []*Transaction, lookup outputLookup) ([]inputCheck, error) {
+	var (
+		checks  []inputCheck
+		created = make(map[string]UnspentOutput)
+		spent   = make(map[string]bool)
+	)
+
+	for _, tx := range txs {
+		if err := tx.CheckOutputs(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, tx := range txs {
+		if !tx.IsCoinbase() {
+			var prevOuts []TXO
+
+			for inIdx, in := range tx.Inputs {
+				key := string(outpointKey(in.ID, in.Out))
+
+				prevOut, ok := created[key]
+				if !ok && !spent[key] {
+					prevOut, ok = lookup(in.ID, in.Out)
+				}
+				if !ok || spent[key] {
+					return nil, fmt.Errorf("%w: transaction %x, input %d spends %x:%d", errMissingOutput, tx.ID, inIdx, in.ID, in.Out)
+				}
+				spent[key] = true
+
+				prevOuts = append(prevOuts, prevOut.Output)
+				checks = append(checks, inputCheck{tx, inIdx, prevOut.Output})
+			}
+
+			if err := checkValueBalance(tx, prevOuts); err != nil {
+				return nil, err
+			}
+		}
+
+		for outIdx, out := range tx.Output {
+			if !out.IsDataCarrier() {
+				created[string(outpointKey(tx.ID, outIdx))] = UnspentOutput{Output: out}
+			}
+		}
+	}
+
+	return checks, nil
+}
+
+// NOTE native inputs must cover native outputs, assets follow checkAssetBalance.
+// NOTE All sums are overflow checked
+func checkValueBalance(tx *Transaction, prevOuts []TXO) error {
+	ins, err := sumByAsset(prevOuts)
+	if err != nil {
+		return fmt.Errorf("transaction %x inputs: %w", tx.ID, err)
+	}
+
+	outs, err := sumByAsset(tx.Output)
+	if err != nil {
+		return fmt.Errorf("transaction %x outputs: %w", tx.ID, err)
+	}
+
+	if in, out := ins[""], outs[""]; out > in {
+		return fmt.Errorf("%w: transaction %x, %s > %s", errValueOut, tx.ID, out, in)
+	}
+
+	return checkAssetBalance(tx, prevOuts, ins, outs)
+}
+
+func runInputChecks(checks []inputCheck, workers int, cache bool) error {
+	if len(checks) == 0 {
+		return nil
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(checks) {
+		workers = len(checks)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		jobs     = make(chan inputCheck)
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for check := range jobs {
+				// NOTE somebody already failed, just drain the channel
+				if ctx.Err() != nil {
+					continue
+				}
+
+				if !SignatureCache.VerifyInput(check.tx, check.inIdx, check.prevOut, cache) {
+					once.Do(func() {
+						firstErr = fmt.Errorf("invalid signature in transaction %x, input %d", check.tx.ID, check.inIdx)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+Feed:
+	for _, check := range checks {
+		select {
+		case jobs <- check:
+		case <-ctx.Done():
+			break Feed
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+// NOTE FindTransactions looks up several transactions with a single walk over the chain,
+// NOTE ids are hex encoded. The walk stops as soon as everything is found
+func (chain *Blockchain) FindTransactions(ids map[string]bool) map[string]Transaction {
+	found := make(map[string]Transaction, len(ids))
+
+	if len(ids) == 0 {
+		return found
+	}
+
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			id := hex.EncodeToString(tx.ID)
+
+			if _, ok := found[id]; ids[id] && !ok {
+				found[id] = *tx
+			}
+		}
+
+		if len(found) == len(ids) || len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	// NOTE transactions of pruned blocks, see prune.go
+	for id := range ids {
+		if _, ok := found[id]; ok {
+			continue
+		}
+
+		txID, err := hex.DecodeString(id)
+		if err != nil {
+			continue
+		}
+
+		if tx, ok := chain.transactionFromSet(txID); ok {
+			found[id] = tx
+		}
+	}
+
+	return found
+}
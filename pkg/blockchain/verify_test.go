@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"blockchain/pkg/blockchain/wallet"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NOTE signed inputs of `count` transactions, the one at `bad` spends an output of another key
+func signedInputChecks(t *testing.T, count, bad int) []inputCheck {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	var checks []inputCheck
+	for i := 0; i < count; i++ {
+		tx, prevOuts := sighashTestTx(*private)
+		tx.ID = []byte(fmt.Sprintf("tx%d", i))
+
+		assert.NoError(t, tx.SignInput(*private, 0, prevOuts[0], SigHashAll))
+		if i == bad {
+			// NOTE SignInput refuses a key which doesn't own the output
+			signature, err := tx.SignatureFor(*other, 0, prevOuts[0], SigHashAll)
+			assert.NoError(t, err)
+			tx.Inputs[0].Signature, tx.Inputs[0].PubKey = signature, wallet.PublicKeyBytes(other.PublicKey)
+		}
+
+		checks = append(checks, inputCheck{tx, 0, prevOuts[0]})
+	}
+
+	return checks
+}
+
+func TestRunInputChecks(t *testing.T) {
+	defer func(cache *SigCache) { SignatureCache = cache }(SignatureCache)
+
+	for _, workers := range []int{1, 4} {
+		SignatureCache = NewSigCache(DefaultSigCacheSize)
+		assert.NoError(t, runInputChecks(signedInputChecks(t, 20, -1), workers, true))
+		assert.Equal(t, 20, SignatureCache.Len())
+
+		SignatureCache = NewSigCache(DefaultSigCacheSize)
+		err := runInputChecks(signedInputChecks(t, 20, 7), workers, true)
+		assert.EqualError(t, err, fmt.Sprintf("invalid signature in transaction %x, input 0", "tx7"))
+	}
+
+	// NOTE a single worker stops at the first bad input, nothing after it is verified
+	SignatureCache = NewSigCache(DefaultSigCacheSize)
+	assert.Error(t, runInputChecks(signedInputChecks(t, 50, 0), 1, true))
+	assert.Equal(t, 0, SignatureCache.Len())
+
+	assert.NoError(t, runInputChecks(nil, 4, true))
+}
+
+// NOTE outputs come from the unspent set, and none is spent twice, within a block or across blocks
+func TestVerifyBlockSpends(t *testing.T) {
+	addresses, wallets := newTestWallets(t, 2)
+	alice, bob := addresses[0], addresses[1]
+	aliceW := wallets[0]
+
+	chain, UTXO := newTestChain(t, "spends", alice)
+	genesis := chain.LastHash
+
+	first := NewTransaction(aliceW, bob, Coin, UTXO)
+	second := NewTransaction(aliceW, bob, 2*Coin, UTXO)
+	assert.NoError(t, chain.VerifyTransactions([]*Transaction{first}))
+	assert.ErrorIs(t, chain.VerifyTransactions([]*Transaction{first, second}), errMissingOutput)
+
+	block := CreateBlock([]*Transaction{CoinbaseTx(alice, ""), first, second}, genesis, 1)
+	assert.ErrorIs(t, chain.AddBlock(block), errMissingOutput)
+	assert.Equal(t, genesis, chain.LastHash)
+
+	mineTestBlock(chain, UTXO, alice, first)
+	assert.ErrorIs(t, chain.VerifyTransactions([]*Transaction{second}), errMissingOutput)
+	assert.False(t, chain.VerifyTransaction(second))
+
+	// NOTE the set moved on, a block on the genesis can't be verified against it
+	stale := CreateBlock([]*Transaction{CoinbaseTx(bob, "")}, genesis, 1)
+	assert.ErrorIs(t, chain.VerifyBlock(stale), errSetTip)
+
+	next := CreateBlock([]*Transaction{CoinbaseTx(bob, "")}, chain.LastHash, 2)
+	assert.NoError(t, chain.VerifyBlock(next))
+	assert.NoError(t, chain.AddBlock(next))
+	assert.Equal(t, next.Hash, chain.LastHash)
+}
@@ -81,11 +81,38 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	utils.DisplayErr(err)
 
 	// NOTE remember the struct. We simply combine X/Y intercepts into pubKey
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	pub := PublicKeyBytes(private.PublicKey)
 
 	return *private, pub
 }
 
+// NOTE X || Y, each padded to the curve size so the halves can always be split back
+func PublicKeyBytes(key ecdsa.PublicKey) []byte {
+	size := (key.Curve.Params().BitSize + 7) / 8
+
+	pub := make([]byte, 2*size)
+	key.X.FillBytes(pub[:size])
+	key.Y.FillBytes(pub[size:])
+
+	return pub
+}
+
+// NOTE X || Y as wallets stored it before padding. About one key in 128 came out shorter
+// NOTE than 64 bytes, and the addresses of those wallets hash that short form
+func LegacyPublicKeyBytes(key ecdsa.PublicKey) []byte {
+	return append(key.X.Bytes(), key.Y.Bytes()...)
+}
+
+// NOTE PublicKeyForms lists the forms outputs may be locked to: padded, then the legacy one when it differs
+func PublicKeyForms(key ecdsa.PublicKey) [][]byte {
+	padded, legacy := PublicKeyBytes(key), LegacyPublicKeyBytes(key)
+	if bytes.Equal(padded, legacy) {
+		return [][]byte{padded}
+	}
+
+	return [][]byte{padded, legacy}
+}
+
 func PublicKey(pubkey []byte) []byte {
 	sha1 := sha.ComputeHash(pubkey)
 
@@ -118,6 +145,21 @@ func ValidateAddress(address string) bool {
 	return bytes.Equal(actualChecksum, targetChecksum)
 }
 
+// NOTE strip version byte and checksum, what is left is the hash outputs are locked to
+func AddressPubKeyHash(address string) []byte {
+	pubKeyHash := utils.Base58Decode([]byte(address))
+
+	return pubKeyHash[1 : len(pubKeyHash)-checksumLength]
+}
+
+// NOTE the other way round, address of the hash outputs are locked to
+func PubKeyHashAddress(pubKeyHash []byte) string {
+	versionHash := append([]byte{version}, pubKeyHash...)
+	fullHash := append(versionHash, checkSum(versionHash)...)
+
+	return string(utils.Base58Encode(fullHash))
+}
+
 func makeWallet() *Wallet {
 	private, public := newKeyPair()
 	wallet := Wallet{private, public}
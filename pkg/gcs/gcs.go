@@ -0,0 +1,197 @@
+// NOTE Golomb-coded set - a compact, probabilistic set. Items are hashed with a key to
+// NOTE numbers in [0, N*M), sorted, and the gaps between them are Golomb-Rice coded:
+// NOTE quotient gap >> P in unary, then the low P bits. A query hashes the same way and
+// NOTE looks for its number. No false negatives, false positives at about 1 in M.
+// NOTE Serialized as uvarint N followed by the bit stream
+
+package gcs
+
+import (
+	"blockchain/pkg/sha"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+const (
+	P = 19
+	M = 784931
+)
+
+var errFilter = errors.New("gcs filter is truncated")
+
+type Filter struct {
+	n    uint64
+	data []byte
+}
+
+// NOTE first 8 bytes of sha(key || item), scaled to [0, n*M) without a modulo
+func hashToRange(key, item []byte, modulus uint64) uint64 {
+	hash := sha.ComputeHash(append(append([]byte{}, key...), item...))
+	high, _ := bits.Mul64(binary.BigEndian.Uint64(hash[:8]), modulus)
+
+	return high
+}
+
+func hashedSorted(key []byte, items [][]byte, modulus uint64) []uint64 {
+	values := make([]uint64, 0, len(items))
+	for _, item := range items {
+		values = append(values, hashToRange(key, item, modulus))
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	return values
+}
+
+// NOTE Build makes the filter of items, duplicates count once
+func Build(key []byte, items [][]byte) *Filter {
+	var (
+		unique [][]byte
+		seen   = make(map[string]bool)
+	)
+
+	for _, item := range items {
+		if !seen[string(item)] {
+			seen[string(item)] = true
+			unique = append(unique, item)
+		}
+	}
+
+	filter := &Filter{n: uint64(len(unique))}
+	w := &bitWriter{}
+
+	var last uint64
+	for _, value := range hashedSorted(key, unique, filter.n*M) {
+		gap := value - last
+		last = value
+
+		for q := gap >> P; q > 0; q-- {
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+		w.writeBits(gap, P)
+	}
+
+	filter.data = w.bytes
+	return filter
+}
+
+// NOTE FromBytes reads a filter written by Bytes
+func FromBytes(data []byte) (*Filter, error) {
+	n, read := binary.Uvarint(data)
+	if read <= 0 {
+		return nil, errFilter
+	}
+
+	return &Filter{n: n, data: data[read:]}, nil
+}
+
+func (f *Filter) Bytes() []byte {
+	return append(binary.AppendUvarint(nil, f.n), f.data...)
+}
+
+// NOTE N is the number of items in the filter
+func (f *Filter) N() int {
+	return int(f.n)
+}
+
+func (f *Filter) Match(key, item []byte) bool {
+	return f.MatchAny(key, [][]byte{item})
+}
+
+// NOTE MatchAny tells whether any of items may be in the set, walking the filter once
+func (f *Filter) MatchAny(key []byte, items [][]byte) bool {
+	if f.n == 0 || len(items) == 0 {
+		return false
+	}
+
+	targets := hashedSorted(key, items, f.n*M)
+	r := &bitReader{data: f.data}
+
+	var value uint64
+	for i := uint64(0); i < f.n; i++ {
+		gap, err := r.readGolomb()
+		if err != nil {
+			return false
+		}
+		value += gap
+
+		for len(targets) > 0 && targets[0] < value {
+			targets = targets[1:]
+		}
+		if len(targets) == 0 {
+			return false
+		}
+		if targets[0] == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+type bitWriter struct {
+	bytes []byte
+	used  uint8 // NOTE bits of the last byte taken
+}
+
+func (w *bitWriter) writeBit(bit uint8) {
+	if w.used == 0 {
+		w.bytes = append(w.bytes, 0)
+		w.used = 8
+	}
+
+	w.used--
+	w.bytes[len(w.bytes)-1] |= bit << w.used
+}
+
+// NOTE low `count` bits of value, most significant first
+func (w *bitWriter) writeBits(value uint64, count int) {
+	for i := count - 1; i >= 0; i-- {
+		w.writeBit(uint8(value>>i) & 1)
+	}
+}
+
+type bitReader struct {
+	data []byte
+	pos  int // NOTE in bits
+}
+
+func (r *bitReader) readBit() (uint8, error) {
+	if r.pos >= len(r.data)*8 {
+		return 0, errFilter
+	}
+
+	bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+
+	return bit, nil
+}
+
+func (r *bitReader) readGolomb() (uint64, error) {
+	var quotient uint64
+
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		quotient++
+	}
+
+	remainder := uint64(0)
+	for i := 0; i < P; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		remainder = remainder<<1 | uint64(bit)
+	}
+
+	return quotient<<P | remainder, nil
+}
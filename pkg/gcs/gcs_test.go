@@ -0,0 +1,81 @@
+package gcs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var key = []byte("block hash")
+
+func testItems(n int) [][]byte {
+	var items [][]byte
+
+	for i := 0; i < n; i++ {
+		items = append(items, []byte(fmt.Sprintf("item%d", i)))
+	}
+
+	return items
+}
+
+func TestBuildMatch(t *testing.T) {
+	items := testItems(50)
+	filter := Build(key, items)
+	assert.Equal(t, 50, filter.N())
+
+	for _, item := range items {
+		assert.True(t, filter.Match(key, item))
+	}
+	assert.True(t, filter.MatchAny(key, [][]byte{[]byte("absent"), items[17]}))
+
+	// NOTE another key, other positions
+	assert.False(t, filter.MatchAny([]byte("other block"), items))
+
+	decoded, err := FromBytes(filter.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, filter, decoded)
+}
+
+func TestEmpty(t *testing.T) {
+	filter := Build(key, nil)
+	assert.Equal(t, 0, filter.N())
+	assert.False(t, filter.Match(key, []byte("item0")))
+
+	decoded, err := FromBytes(filter.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, decoded.N())
+
+	assert.False(t, Build(key, testItems(3)).MatchAny(key, nil))
+}
+
+func TestDuplicates(t *testing.T) {
+	items := testItems(5)
+	filter := Build(key, append(items, items...))
+
+	assert.Equal(t, 5, filter.N())
+	assert.Equal(t, Build(key, items).Bytes(), filter.Bytes())
+}
+
+func TestTruncated(t *testing.T) {
+	_, err := FromBytes(nil)
+	assert.ErrorIs(t, err, errFilter)
+
+	items := testItems(20)
+	data := Build(key, items).Bytes()
+
+	// NOTE a cut filter never panics, items past the cut just don't match
+	for cut := 1; cut < len(data); cut++ {
+		filter, err := FromBytes(data[:cut])
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NotPanics(t, func() { filter.MatchAny(key, items) })
+	}
+
+	// NOTE the item count without the bitstream
+	filter, err := FromBytes(data[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, 20, filter.N())
+	assert.False(t, filter.MatchAny(key, items))
+}
@@ -0,0 +1,100 @@
+// NOTE MuHash - hash of a set which can be updated one element at a time, in any order.
+// NOTE Every element is expanded to a 3072-bit number, the set is their product modulo
+// NOTE the prime 2^3072 - 1103717. Removal multiplies the denominator instead, so the
+// NOTE expensive inverse is computed only once, in Digest. Same set -> same digest,
+// NOTE no matter how it was built
+
+package muhash
+
+import (
+	"blockchain/pkg/sha"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+const (
+	Size = 384 // NOTE bytes of a 3072-bit number
+
+	primeOffset = 1103717
+)
+
+var (
+	prime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), Size*8), big.NewInt(primeOffset))
+
+	errState = errors.New("muhash state must be two 384 byte numbers")
+)
+
+type MuHash struct {
+	numerator   *big.Int
+	denominator *big.Int
+}
+
+// NOTE hash of the empty set
+func New() *MuHash {
+	return &MuHash{big.NewInt(1), big.NewInt(1)}
+}
+
+// NOTE 12 SHA-256 blocks of counter || data give the 3072 bits of an element
+func element(data []byte) *big.Int {
+	expanded := make([]byte, 0, Size)
+	block := make([]byte, 4+len(data))
+	copy(block[4:], data)
+
+	for i := uint32(0); len(expanded) < Size; i++ {
+		binary.BigEndian.PutUint32(block, i)
+		hash := sha.ComputeHash(block)
+		expanded = append(expanded, hash[:]...)
+	}
+
+	e := new(big.Int).SetBytes(expanded)
+	e.Mod(e, prime)
+
+	// NOTE zero would wipe the whole product, it practically never happens
+	if e.Sign() == 0 {
+		e.SetInt64(1)
+	}
+
+	return e
+}
+
+func (m *MuHash) Insert(data []byte) {
+	m.numerator.Mul(m.numerator, element(data))
+	m.numerator.Mod(m.numerator, prime)
+}
+
+func (m *MuHash) Remove(data []byte) {
+	m.denominator.Mul(m.denominator, element(data))
+	m.denominator.Mod(m.denominator, prime)
+}
+
+// NOTE Digest is the SHA-256 of numerator / denominator
+func (m *MuHash) Digest() []byte {
+	inverse := new(big.Int).ModInverse(m.denominator, prime)
+
+	value := new(big.Int).Mul(m.numerator, inverse)
+	value.Mod(value, prime)
+
+	hash := sha.ComputeHash(value.FillBytes(make([]byte, Size)))
+
+	return hash[:]
+}
+
+func (m *MuHash) MarshalBinary() ([]byte, error) {
+	state := make([]byte, 2*Size)
+	m.numerator.FillBytes(state[:Size])
+	m.denominator.FillBytes(state[Size:])
+
+	return state, nil
+}
+
+func (m *MuHash) UnmarshalBinary(state []byte) error {
+	if len(state) != 2*Size {
+		return errState
+	}
+
+	m.numerator = new(big.Int).SetBytes(state[:Size])
+	m.denominator = new(big.Int).SetBytes(state[Size:])
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package muhash
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var elements = [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+
+func TestInsertOrder(t *testing.T) {
+	forward, backward := New(), New()
+
+	for i := range elements {
+		forward.Insert(elements[i])
+		backward.Insert(elements[len(elements)-1-i])
+	}
+
+	assert.Equal(t, forward.Digest(), backward.Digest())
+
+	// NOTE another set, another digest
+	other := New()
+	for _, element := range elements[:3] {
+		other.Insert(element)
+	}
+	assert.NotEqual(t, forward.Digest(), other.Digest())
+}
+
+func TestRemove(t *testing.T) {
+	m := New()
+
+	for _, element := range elements {
+		m.Insert(element)
+	}
+	for _, element := range elements {
+		m.Remove(element)
+	}
+	assert.Equal(t, New().Digest(), m.Digest())
+
+	// NOTE removal before insertion ends up in the same set
+	reordered := New()
+	reordered.Remove(elements[0])
+	reordered.Insert(elements[1])
+	reordered.Insert(elements[0])
+
+	single := New()
+	single.Insert(elements[1])
+	assert.Equal(t, single.Digest(), reordered.Digest())
+}
+
+func TestMarshalBinary(t *testing.T) {
+	m := New()
+	m.Insert(elements[0])
+	m.Insert(elements[1])
+	m.Remove(elements[2])
+
+	state, err := m.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, state, 2*Size)
+
+	restored := New()
+	assert.NoError(t, restored.UnmarshalBinary(state))
+	assert.Equal(t, m.Digest(), restored.Digest())
+
+	// NOTE the restored state keeps working like the original
+	m.Insert(elements[3])
+	restored.Insert(elements[3])
+	assert.Equal(t, m.Digest(), restored.Digest())
+
+	assert.ErrorIs(t, restored.UnmarshalBinary(state[1:]), errState)
+}
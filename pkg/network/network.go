@@ -2,10 +2,12 @@ package network
 
 import (
 	"blockchain/pkg/blockchain"
+	"blockchain/pkg/blockchain/wallet"
 	"blockchain/pkg/utils"
 	"bytes"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -28,6 +30,9 @@ var (
 	KnownNodes      = []string{"localhost:3000"}              // NOTE contain all addresses which are connected to the network
 	blocksInTransit = [][]byte{}                              // NOTE description of blocks which are in transit
 	memoryPool      = make(map[string]blockchain.Transaction) // NOTE contain block transactions
+	spvMode         bool                                      // NOTE light node, see spv.go
+	spvWatched      [][]byte                                  // NOTE pubkey hashes of the wallet of a light node
+	cfilterMissing  = make(map[string]bool)                   // NOTE peers which answered notfound for filters
 )
 
 type (
@@ -59,6 +64,12 @@ type (
 		AddrFrom    string
 		Transaction []byte
 	}
+	// NOTE reply to getdata for what the node doesn't have, e.g. pruned blocks
+	NotFound struct {
+		AddrFrom string
+		Type     string
+		ID       []byte
+	}
 
 	// NOTE sync blockchain between each other
 	// NOTE 1. server connect to "SPV" node
@@ -69,6 +80,37 @@ type (
 		Version    int
 		BestHeight int
 		AddrFrom   string
+		// NOTE light nodes have headers only, nobody downloads blocks from them
+		SPV bool
+	}
+
+	// NOTE light node sync: headers from a height up, then proofs of its transactions
+	GetHeaders struct {
+		AddrFrom string
+		From     int
+	}
+	Headers struct {
+		AddrFrom string
+		Headers  [][]byte
+	}
+	GetProofs struct {
+		AddrFrom     string
+		PubKeyHashes [][]byte
+	}
+	Proofs struct {
+		AddrFrom string
+		Proofs   [][]byte
+	}
+	// NOTE compact block filters from a height up, one cfilter message per block
+	GetCFilters struct {
+		AddrFrom string
+		From     int
+	}
+	CFilter struct {
+		AddrFrom  string
+		BlockHash []byte
+		Filter    []byte
+		Header    []byte
 	}
 )
 
@@ -177,6 +219,66 @@ func SendGetData(address, kind string, id []byte) {
 	SendData(address, request)
 }
 
+func SendNotFound(address, kind string, id []byte) {
+	payload := GobEncode(NotFound{nodeAddress, kind, id})
+	request := append(CmdToBytes("notfound"), payload...)
+
+	SendData(address, request)
+}
+
+func SendGetHeaders(address string, from int) {
+	payload := GobEncode(GetHeaders{nodeAddress, from})
+	request := append(CmdToBytes("getheaders"), payload...)
+
+	SendData(address, request)
+}
+
+func SendHeaders(address string, headers []*blockchain.Block) {
+	var data [][]byte
+	for _, header := range headers {
+		data = append(data, header.Serialize())
+	}
+
+	payload := GobEncode(Headers{nodeAddress, data})
+	request := append(CmdToBytes("headers"), payload...)
+
+	SendData(address, request)
+}
+
+// NOTE the full node learns which addresses the light node watches
+func SendGetProofs(address string) {
+	payload := GobEncode(GetProofs{nodeAddress, spvWatched})
+	request := append(CmdToBytes("getproofs"), payload...)
+
+	SendData(address, request)
+}
+
+func SendProofs(address string, proofs []blockchain.TxProof) {
+	var data [][]byte
+	for _, proof := range proofs {
+		data = append(data, proof.Serialize())
+	}
+
+	payload := GobEncode(Proofs{nodeAddress, data})
+	request := append(CmdToBytes("proofs"), payload...)
+
+	SendData(address, request)
+}
+
+func SendGetCFilters(address string, from int) {
+	payload := GobEncode(GetCFilters{nodeAddress, from})
+	request := append(CmdToBytes("getcfilters"), payload...)
+
+	SendData(address, request)
+}
+
+func SendCFilter(address string, blockHash, filter, header []byte) {
+	payload := GobEncode(CFilter{nodeAddress, blockHash, filter, header})
+	request := append(CmdToBytes("cfilter"), payload...)
+
+	SendData(address, request)
+}
+
 func SendTx(addr string, tnx *blockchain.Transaction) {
 	data := Tx{nodeAddress, tnx.Serialize()}
 	payload := GobEncode(data)
@@ -186,8 +288,8 @@ func SendTx(addr string, tnx *blockchain.Transaction) {
 }
 
 func SendVersion(addr string, chain *blockchain.Blockchain) {
-	bestHeight, _ := chain.GetBestHeightAndLastHash()
-	payload := GobEncode(Version{version, bestHeight, nodeAddress})
+	bestHeight := chain.BestHeight()
+	payload := GobEncode(Version{version, bestHeight, nodeAddress, spvMode})
 
 	request := append(CmdToBytes("version"), payload...)
 
@@ -220,8 +322,26 @@ func HandleBlock(request []byte, chain *blockchain.Blockchain) {
 	blockData := payload.Block
 	block := blockchain.DeserializeBlock(blockData)
 
+	// NOTE a light node asks for blocks whose filter matches its wallet, it keeps
+	// NOTE proofs of their wallet transactions instead of the blocks
+	if spvMode {
+		added, err := chain.AddBlockProofs(block, spvWatched)
+		if err != nil {
+			fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+			return
+		}
+
+		if added > 0 {
+			printSPVBalances(chain)
+		}
+		return
+	}
+
 	fmt.Println("Recevied a new block!")
-	chain.AddBlock(block)
+	if err := chain.AddBlock(block); err != nil {
+		fmt.Printf("Rejected %s\n", err)
+		return
+	}
 
 	fmt.Printf("Added block %x\n", block.Hash)
 
@@ -232,10 +352,42 @@ func HandleBlock(request []byte, chain *blockchain.Blockchain) {
 		blocksInTransit = blocksInTransit[1:]
 	} else {
 		UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+		// NOTE a node started from a snapshot checks it against the history it just got,
+		// NOTE the set is rebuilt only from a history which agrees with it
+		if _, pending := chain.SnapshotPending(); pending {
+			if err := UTXOSet.ValidateSnapshot(); err != nil {
+				fmt.Printf("Snapshot is not validated: %s\n", err)
+				stopOnBadSnapshot(chain, err)
+				return
+			}
+			fmt.Println("Snapshot validated against the history")
+		}
+
+		// NOTE a pruned node can't rebuild its set, it applies the new blocks instead
+		if _, pruned := chain.Pruning(); pruned {
+			if err := UTXOSet.CatchUp(); err != nil {
+				fmt.Printf("Can't apply the new blocks: %s\n", err)
+			}
+			return
+		}
+
 		UTXOSet.Reindex()
 	}
 }
 
+// NOTE a snapshot which the history contradicts is wrong, the node would keep serving
+// NOTE balances from it. It stops instead, and stops again on every start (see StartServer)
+func stopOnBadSnapshot(chain *blockchain.Blockchain, err error) {
+	if !errors.Is(err, blockchain.ErrSnapshotHash) {
+		return
+	}
+
+	fmt.Println("The snapshot doesn't match the history, delete the database and sync again")
+	chain.Database.Close()
+	os.Exit(1)
+}
+
 func HandleInv(request []byte, chain *blockchain.Blockchain) {
 	var buff bytes.Buffer
 	var payload Inv
@@ -247,6 +399,14 @@ func HandleInv(request []byte, chain *blockchain.Blockchain) {
 
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
+	// NOTE a light node asks for the headers of new blocks and ignores transactions
+	if spvMode {
+		if payload.Type == "block" {
+			SendGetHeaders(payload.AddrFrom, chain.BestHeight()+1)
+		}
+		return
+	}
+
 	if payload.Type == "block" {
 		blocksInTransit = payload.Items
 
@@ -280,6 +440,11 @@ func HandleGetBlocks(request []byte, chain *blockchain.Blockchain) {
 	err := dec.Decode(&payload)
 	utils.DisplayErr(err)
 
+	// NOTE a light node has no blocks to offer
+	if spvMode {
+		return
+	}
+
 	blocks := chain.GetAllHashes()
 	SendInv(payload.AddrFrom, "block", blocks)
 }
@@ -295,7 +460,10 @@ func HandleGetData(request []byte, chain *blockchain.Blockchain) {
 
 	if payload.Type == "block" {
 		block := chain.GetBlock([]byte(payload.ID))
-		if err != nil {
+
+		// NOTE a pruned node has only the header of old blocks
+		if block.Hash == nil || block.IsPruned() {
+			SendNotFound(payload.AddrFrom, "block", payload.ID)
 			return
 		}
 
@@ -310,6 +478,200 @@ func HandleGetData(request []byte, chain *blockchain.Blockchain) {
 	}
 }
 
+// NOTE the peer can't serve the block, the download goes on with the next one
+func HandleNotFound(request []byte, chain *blockchain.Blockchain) {
+	var buff bytes.Buffer
+	var payload NotFound
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	utils.DisplayErr(err)
+
+	fmt.Printf("%s doesn't have %s %x\n", payload.AddrFrom, payload.Type, payload.ID)
+
+	// NOTE another peer may have the filters. Proofs are never asked for instead,
+	// NOTE that would tell the peer the addresses of the wallet
+	if payload.Type == "cfilter" && spvMode {
+		cfilterMissing[payload.AddrFrom] = true
+
+		for _, node := range KnownNodes {
+			if node != nodeAddress && !cfilterMissing[node] {
+				SendGetCFilters(node, chain.CFilterHeight()+1)
+				return
+			}
+		}
+
+		fmt.Println("No known node has the filters, the balances are not complete")
+		return
+	}
+
+	if payload.Type == "block" && len(blocksInTransit) > 0 {
+		blockHash := blocksInTransit[0]
+		SendGetData(payload.AddrFrom, "block", blockHash)
+
+		blocksInTransit = blocksInTransit[1:]
+	}
+}
+
+func HandleGetHeaders(request []byte, chain *blockchain.Blockchain) {
+	var buff bytes.Buffer
+	var payload GetHeaders
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	utils.DisplayErr(err)
+
+	headers, err := chain.Headers(payload.From)
+	if err != nil {
+		fmt.Printf("Can't send headers: %s\n", err)
+		return
+	}
+
+	SendHeaders(payload.AddrFrom, headers)
+}
+
+// NOTE light node: a full batch means there are more, otherwise the headers are
+// NOTE synced and the filters of the new blocks are asked for
+func HandleHeaders(request []byte, chain *blockchain.Blockchain) {
+	var buff bytes.Buffer
+	var payload Headers
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	utils.DisplayErr(err)
+
+	for _, data := range payload.Headers {
+		if _, err := chain.AddHeader(blockchain.DeserializeBlock(data)); err != nil {
+			fmt.Printf("Rejected header from %s: %s\n", payload.AddrFrom, err)
+			return
+		}
+	}
+
+	fmt.Printf("Headers synced to height %d\n", chain.BestHeight())
+
+	if len(payload.Headers) == blockchain.MaxHeadersPerMessage {
+		SendGetHeaders(payload.AddrFrom, chain.BestHeight()+1)
+		return
+	}
+
+	SendGetCFilters(payload.AddrFrom, chain.CFilterHeight()+1)
+}
+
+func HandleGetProofs(request []byte, chain *blockchain.Blockchain) {
+	var buff bytes.Buffer
+	var payload GetProofs
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	utils.DisplayErr(err)
+
+	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+
+	var proofs []blockchain.TxProof
+	for _, pubKeyHash := range payload.PubKeyHashes {
+		found, err := UTXOSet.AddressTxProofs(pubKeyHash)
+		if err != nil {
+			fmt.Printf("Can't prove transactions of %x: %s\n", pubKeyHash, err)
+			continue
+		}
+
+		proofs = append(proofs, found...)
+	}
+
+	SendProofs(payload.AddrFrom, proofs)
+}
+
+// NOTE light node: proofs which don't lead to its headers are dropped
+func HandleProofs(request []byte, chain *blockchain.Blockchain) {
+	var buff bytes.Buffer
+	var payload Proofs
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	utils.DisplayErr(err)
+
+	for _, data := range payload.Proofs {
+		proof, err := blockchain.DeserializeTxProof(data)
+		if err == nil {
+			err = chain.AddTxProof(proof)
+		}
+		if err != nil {
+			fmt.Printf("Rejected proof from %s: %s\n", payload.AddrFrom, err)
+		}
+	}
+
+	printSPVBalances(chain)
+}
+
+func printSPVBalances(chain *blockchain.Blockchain) {
+	for _, pubKeyHash := range spvWatched {
+		balances, err := chain.ProvenBalances(pubKeyHash)
+		utils.DisplayErr(err)
+
+		fmt.Printf("Balance of %s: %s\n", wallet.PubKeyHashAddress(pubKeyHash), balances[""])
+	}
+}
+
+// NOTE a node without filters below some height, e.g. of blocks connected before
+// NOTE they existed, answers notfound from there
+func HandleGetCFilters(request []byte, chain *blockchain.Blockchain) {
+	var buff bytes.Buffer
+	var payload GetCFilters
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	utils.DisplayErr(err)
+
+	headers, err := chain.Headers(payload.From)
+	if err != nil {
+		fmt.Printf("Can't send filters: %s\n", err)
+		return
+	}
+
+	for _, header := range headers {
+		filter, filterHeader, err := chain.CFilter(header.Hash)
+		if err != nil {
+			SendNotFound(payload.AddrFrom, "cfilter", header.Hash)
+			return
+		}
+
+		SendCFilter(payload.AddrFrom, header.Hash, filter, filterHeader)
+	}
+}
+
+// NOTE light node: blocks whose filter matches the wallet are downloaded
+func HandleCFilter(request []byte, chain *blockchain.Blockchain) {
+	var buff bytes.Buffer
+	var payload CFilter
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	utils.DisplayErr(err)
+
+	if err := chain.AddCFilter(payload.BlockHash, payload.Filter, payload.Header); err != nil {
+		fmt.Printf("Rejected filter from %s: %s\n", payload.AddrFrom, err)
+		return
+	}
+	delete(cfilterMissing, payload.AddrFrom)
+
+	match, err := blockchain.MatchCFilter(payload.BlockHash, payload.Filter, chain.WalletFilterItems(spvWatched))
+	if err != nil {
+		fmt.Printf("Rejected filter from %s: %s\n", payload.AddrFrom, err)
+		return
+	}
+
+	if match {
+		SendGetData(payload.AddrFrom, "block", payload.BlockHash)
+	}
+}
+
 func HandleTx(request []byte, chain *blockchain.Blockchain) {
 	var (
 		buff    bytes.Buffer
@@ -324,7 +686,20 @@ func HandleTx(request []byte, chain *blockchain.Blockchain) {
 
 	txData := payload.Transaction
 
-	blockchain.DeserializeTransactions(txData, tx)
+	tx = blockchain.DeserializeTransaction(txData)
+
+	// NOTE a light node can't verify transactions
+	if spvMode {
+		return
+	}
+
+	// NOTE signatures are checked once on the way into the pool,
+	// NOTE MineTx and MineBlock then find them in the signature cache
+	if !chain.VerifyTransaction(&tx) {
+		fmt.Printf("Rejected invalid transaction %x\n", tx.ID)
+		return
+	}
+
 	memoryPool[hex.EncodeToString(tx.ID)] = tx
 
 	fmt.Printf("%s, %d", nodeAddress, len(memoryPool))
@@ -363,7 +738,10 @@ func MineTx(chain *blockchain.Blockchain) {
 
 	newBlock := chain.MineBlock(txs)
 	UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
-	UTXOSet.Reindex()
+	if err := UTXOSet.Update(newBlock); err != nil {
+		fmt.Printf("Can't apply the new block: %s\n", err)
+		return
+	}
 
 	fmt.Println("New Block mined")
 
@@ -392,13 +770,22 @@ func HandleVersion(request []byte, chain *blockchain.Blockchain) {
 	err := dec.Decode(&payload)
 	utils.DisplayErr(err)
 
-	bestHeight, _ := chain.GetBestHeightAndLastHash()
+	bestHeight := chain.BestHeight()
 	otherHeight := payload.BestHeight
 
-	if bestHeight < otherHeight {
-		SendGetBlocks(payload.AddrFrom)
-	} else if bestHeight > otherHeight {
-		SendVersion(payload.AddrFrom, chain)
+	if spvMode {
+		if bestHeight < otherHeight && !payload.SPV {
+			SendGetHeaders(payload.AddrFrom, bestHeight+1)
+		}
+	} else {
+		_, pending := chain.SnapshotPending()
+
+		// NOTE a snapshot node has the tip, but still needs the history below it
+		if (bestHeight < otherHeight || pending) && !payload.SPV {
+			SendGetBlocks(payload.AddrFrom)
+		} else if bestHeight > otherHeight {
+			SendVersion(payload.AddrFrom, chain)
+		}
 	}
 
 	if !NodeIsKnown(payload.AddrFrom) {
@@ -427,6 +814,20 @@ func HandleConnection(conn net.Conn, chain *blockchain.Blockchain) {
 		HandleGetData(req, chain)
 	case "tx":
 		HandleTx(req, chain)
+	case "notfound":
+		HandleNotFound(req, chain)
+	case "getheaders":
+		HandleGetHeaders(req, chain)
+	case "headers":
+		HandleHeaders(req, chain)
+	case "getproofs":
+		HandleGetProofs(req, chain)
+	case "proofs":
+		HandleProofs(req, chain)
+	case "getcfilters":
+		HandleGetCFilters(req, chain)
+	case "cfilter":
+		HandleCFilter(req, chain)
 	case "version":
 		HandleVersion(req, chain)
 	default:
@@ -435,18 +836,52 @@ func HandleConnection(conn net.Conn, chain *blockchain.Blockchain) {
 
 }
 
-func StartServer(nodeID, minerAddress string) {
+// NOTE prune > 0 turns the node into a pruned one, keeping the last `prune` blocks.
+// NOTE spv starts a light node, which follows headers and the wallet of the node only
+func StartServer(nodeID, minerAddress string, prune int, spv bool) {
 	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
 	ln, err := net.Listen(protocol, nodeAddress)
 	utils.DisplayErr(err)
 	defer ln.Close()
 
-	chain := blockchain.ContinueBlockchain(nodeID)
+	var chain *blockchain.Blockchain
+	if spv {
+		chain, err = blockchain.OpenHeaderChain(nodeID)
+		utils.DisplayErr(err)
+
+		wallets, _ := wallet.CreateWallets(nodeID)
+		for _, address := range wallets.GetAllAddresses() {
+			spvWatched = append(spvWatched, wallet.AddressPubKeyHash(address))
+		}
+		spvMode = true
+	} else {
+		chain = blockchain.ContinueBlockchain(nodeID)
+	}
 	defer chain.Database.Close()
 	go CloseDB(chain)
 
+	// NOTE the history of a snapshot may be complete already, a wrong one is refused at once
+	if _, pending := chain.SnapshotPending(); pending && !spvMode {
+		UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+		stopOnBadSnapshot(chain, UTXOSet.ValidateSnapshot())
+	}
+
+	if prune > 0 {
+		utils.DisplayErr(chain.SetPruning(prune))
+
+		UTXOSet := blockchain.UnspentTransactionSET{Blockchain: chain}
+		pruned, err := UTXOSet.Prune()
+		utils.DisplayErr(err)
+		fmt.Printf("Pruning is on, keeping %d blocks. Pruned %d now\n", prune, pruned)
+	}
+
 	if nodeAddress != KnownNodes[0] {
 		SendVersion(KnownNodes[0], chain)
+
+		// NOTE filters of blocks the light node already has headers of
+		if spvMode {
+			SendGetCFilters(KnownNodes[0], chain.CFilterHeight()+1)
+		}
 	}
 	for {
 		conn, err := ln.Accept()
@@ -42,7 +42,7 @@ func Base58Encode(b []byte) []byte {
 
 func Base58Decode(b []byte) []byte {
 	decode, err := base58.Decode(string(b[:]))
-	logging.ErrorSingleTon().Error(err)
+	DisplayErr(err)
 
 	return decode
 }